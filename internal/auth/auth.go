@@ -0,0 +1,205 @@
+// Package auth replaces the single shared cfg.AdminToken with per-user
+// credentials and short-lived JWT sessions, so teams can run the admin API
+// without sharing one secret. Passwords are hashed with bcrypt; sessions are
+// HS256 JWTs signed with cfg.JWTSecret.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"apt-cache-proxy/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is a user's permission level.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleViewer   Role = "viewer"
+)
+
+// AccessTokenTTL and RefreshTokenTTL bound how long a session and its
+// refresh window last before the client must log in again.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// TokenType discriminates a short-lived access token (presented to the admin
+// API on every request) from a long-lived refresh token (presented only to
+// /api/auth/refresh to mint a new access token). Without this, the two are
+// indistinguishable JWTs and a leaked/long-lived refresh token would work as
+// a full-privilege access token for its entire TTL.
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
+// Claims is the payload carried in an apt-cache-proxy session token.
+type Claims struct {
+	Username string    `json:"username"`
+	Role     Role      `json:"role"`
+	Type     TokenType `json:"type"`
+	jwt.RegisteredClaims
+}
+
+// HashPassword bcrypt-hashes a plaintext password for storage.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches the stored bcrypt hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// IssueToken signs a new session JWT of the given type for username/role,
+// valid for ttl.
+func IssueToken(secret, username string, role Role, tokenType TokenType, ttl time.Duration) (string, error) {
+	claims := Claims{
+		Username: username,
+		Role:     role,
+		Type:     tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// ParseToken validates a session JWT and returns its claims.
+func ParseToken(secret, tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// Allowed reports whether role may perform an HTTP method against an
+// /api/admin/* (or /api/reload) path. Viewers may only read; operators may
+// mutate mirrors/blacklist but not config, reload, or user management;
+// admins may do everything.
+func Allowed(role Role, method, path string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+
+	if method == http.MethodGet {
+		return role == RoleViewer || role == RoleOperator
+	}
+
+	if role != RoleOperator {
+		return false
+	}
+
+	adminOnly := []string{"/api/admin/config", "/api/reload", "/api/admin/users", "/api/admin/cluster"}
+	for _, prefix := range adminOnly {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+type ctxKey string
+
+const claimsKey ctxKey = "auth_claims"
+
+// WithClaims attaches claims to a request context.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// FromContext retrieves the claims attached by WithClaims, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// User is a row of the users table, without its password hash.
+type User struct {
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateUser adds a new admin-API user with a bcrypt-hashed password.
+func CreateUser(username, password string, role Role) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db := database.Get()
+	_, err = db.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)", username, hash, role)
+	return err
+}
+
+// Authenticate verifies username/password and returns the user's role.
+func Authenticate(username, password string) (Role, error) {
+	db := database.Get()
+
+	var hash string
+	var role Role
+	err := db.QueryRow("SELECT password_hash, role FROM users WHERE username = ?", username).Scan(&hash, &role)
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials")
+	}
+
+	if !CheckPassword(hash, password) {
+		return "", fmt.Errorf("invalid credentials")
+	}
+
+	return role, nil
+}
+
+// ListUsers returns all admin-API users (without password hashes).
+func ListUsers() ([]User, error) {
+	db := database.Get()
+	rows, err := db.Query("SELECT username, role, created_at FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.Role, &u.CreatedAt); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser removes an admin-API user.
+func DeleteUser(username string) error {
+	db := database.Get()
+	_, err := db.Exec("DELETE FROM users WHERE username = ?", username)
+	return err
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"time"
+
+	"apt-cache-proxy/internal/database"
+)
+
+// AccessCounter is one row of the access_counters table, exposed to the
+// admin API so an operator can see which URLs are close to the
+// cfg.CacheAfter admission threshold.
+type AccessCounter struct {
+	Distro    string    `json:"distro"`
+	PkgPath   string    `json:"pkg_path"`
+	Hits      int64     `json:"hits"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// RecordAccess increments the access_counters row for distro/pkgPath,
+// creating it on first access, and returns the new hit count. The proxy's
+// handlePackage passes the result to ShouldCache to decide whether a URL has
+// been requested often enough to be worth writing to disk (StreamAndCache,
+// see cfg.CacheAfter) instead of just streamed straight through
+// (StreamPassthrough).
+func RecordAccess(distro, pkgPath string) (int64, error) {
+	db := database.Get()
+	_, err := db.Exec(`INSERT INTO access_counters (pkg_path, distro, hits) VALUES (?, ?, 1)
+		ON CONFLICT(pkg_path, distro) DO UPDATE SET hits = hits + 1`, pkgPath, distro)
+	if err != nil {
+		return 0, err
+	}
+
+	var hits int64
+	err = db.QueryRow(`SELECT hits FROM access_counters WHERE pkg_path = ? AND distro = ?`, pkgPath, distro).Scan(&hits)
+	return hits, err
+}
+
+// ListAccessCounters returns every tracked counter, most-requested first,
+// for the admin API.
+func ListAccessCounters() ([]AccessCounter, error) {
+	db := database.Get()
+	rows, err := db.Query(`SELECT pkg_path, distro, hits, first_seen FROM access_counters ORDER BY hits DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []AccessCounter
+	for rows.Next() {
+		var c AccessCounter
+		if err := rows.Scan(&c.PkgPath, &c.Distro, &c.Hits, &c.FirstSeen); err != nil {
+			continue
+		}
+		counters = append(counters, c)
+	}
+	return counters, nil
+}
+
+// ShouldCache reports whether a URL with hits recorded accesses has crossed
+// the cfg.CacheAfter admission threshold and is therefore worth writing to
+// disk (see RecordAccess, StreamAndCache, StreamPassthrough).
+func ShouldCache(hits int64, cacheAfter int) bool {
+	return hits >= int64(cacheAfter)
+}
+
+// ResetAccessCounter clears the counter for distro/pkgPath, so an operator
+// can force it back below cfg.CacheAfter. A file already written to disk
+// stays cached; only the counter is removed.
+func ResetAccessCounter(distro, pkgPath string) error {
+	db := database.Get()
+	_, err := db.Exec(`DELETE FROM access_counters WHERE pkg_path = ? AND distro = ?`, pkgPath, distro)
+	return err
+}
+
+// ResetAllAccessCounters clears every tracked counter.
+func ResetAllAccessCounters() error {
+	db := database.Get()
+	_, err := db.Exec(`DELETE FROM access_counters`)
+	return err
+}
@@ -0,0 +1,27 @@
+package cache
+
+import "testing"
+
+func TestShouldCache(t *testing.T) {
+	cases := []struct {
+		name       string
+		hits       int64
+		cacheAfter int
+		want       bool
+	}{
+		{"below threshold", 1, 3, false},
+		{"just below threshold", 2, 3, false},
+		{"at threshold", 3, 3, true},
+		{"above threshold", 5, 3, true},
+		{"threshold of zero always caches", 0, 0, true},
+		{"first hit with threshold of one", 1, 1, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ShouldCache(tc.hits, tc.cacheAfter); got != tc.want {
+				t.Errorf("ShouldCache(%d, %d) = %v, want %v", tc.hits, tc.cacheAfter, got, tc.want)
+			}
+		})
+	}
+}
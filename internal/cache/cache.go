@@ -2,8 +2,11 @@ package cache
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
@@ -13,9 +16,13 @@ import (
 	"sync"
 	"time"
 
+	"apt-cache-proxy/internal/chaos"
+	"apt-cache-proxy/internal/cluster"
 	"apt-cache-proxy/internal/config"
 	"apt-cache-proxy/internal/database"
 	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/metrics"
+	"apt-cache-proxy/internal/mirrors"
 )
 
 var (
@@ -23,6 +30,51 @@ var (
 	blacklistMu       sync.RWMutex
 )
 
+func init() {
+	cluster.Register("blacklist.add", applyAddBlacklistCmd)
+	cluster.Register("blacklist.remove", applyRemoveBlacklistCmd)
+	cluster.RegisterSnapshot("blacklist", snapshotBlacklist, restoreBlacklist)
+}
+
+// snapshotBlacklist serializes blacklistPatterns for cluster.Snapshot, so a
+// node that joins after a Raft log truncation (or restores on restart) still
+// gets the full list instead of just whatever blacklist.* commands happened
+// to land in the log afterward.
+func snapshotBlacklist() ([]byte, error) {
+	blacklistMu.RLock()
+	defer blacklistMu.RUnlock()
+	return json.Marshal(blacklistPatterns)
+}
+
+// restoreBlacklist replaces blacklistPatterns wholesale from a snapshot
+// captured by snapshotBlacklist, and persists it to the local database so it
+// survives a plain process restart the same way LoadBlacklistFromDB expects.
+func restoreBlacklist(data []byte) error {
+	var snap []string
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	db := database.Get()
+	if _, err := db.Exec("DELETE FROM package_blacklist"); err != nil {
+		return err
+	}
+	for _, pattern := range snap {
+		if _, err := db.Exec("INSERT OR IGNORE INTO package_blacklist (pattern) VALUES (?)", pattern); err != nil {
+			return err
+		}
+	}
+
+	blacklistMu.Lock()
+	blacklistPatterns = snap
+	blacklistMu.Unlock()
+	return nil
+}
+
+type blacklistCmd struct {
+	Pattern string `json:"pattern"`
+}
+
 // LoadBlacklistFromDB loads blacklist patterns from database
 func LoadBlacklistFromDB() error {
 	db := database.Get()
@@ -51,6 +103,16 @@ func LoadBlacklistFromDB() error {
 
 // IsBlacklisted checks if a filename matches any blacklist pattern
 func IsBlacklisted(filename string) bool {
+	matched, ok := MatchedBlacklistPattern(filename)
+	if ok {
+		metrics.IncBlacklistHit(matched)
+	}
+	return ok
+}
+
+// MatchedBlacklistPattern returns the first blacklist pattern matching
+// filename, if any.
+func MatchedBlacklistPattern(filename string) (string, bool) {
 	blacklistMu.RLock()
 	defer blacklistMu.RUnlock()
 
@@ -60,16 +122,21 @@ func IsBlacklisted(filename string) bool {
 			regexPattern := strings.ReplaceAll(pattern, ".", "\\.")
 			regexPattern = strings.ReplaceAll(regexPattern, "*", ".*")
 			if matched, _ := regexp.MatchString("(?i)"+regexPattern, filename); matched {
-				return true
+				return pattern, true
 			}
 		} else if strings.Contains(strings.ToLower(filename), strings.ToLower(pattern)) {
-			return true
+			return pattern, true
 		}
 	}
-	return false
+	return "", false
 }
 
-// GetCachePath generates a cache file path for a distro and package path
+// GetCachePath generates a cache file path for a distro and package path. The
+// returned path is often a hardlink into the content-addressed pool (see
+// pool.go) rather than an independent copy: once a file's SHA256 is known
+// from a Packages index, identical bytes requested under a different
+// pkgPath/distro are served from the same pool entry instead of being
+// downloaded twice.
 func GetCachePath(distro, pkgPath string) string {
 	cfg := config.Get()
 	hash := md5.Sum([]byte(pkgPath))
@@ -93,21 +160,23 @@ func IsCacheValid(cachePath string) bool {
 		return false
 	}
 
-	// Check if metadata file exists and matches
-	metaPath := cachePath + ".meta"
-	if metaData, err := os.ReadFile(metaPath); err == nil {
-		var expectedSize int64
-		if _, err := fmt.Sscanf(string(metaData), "%d", &expectedSize); err == nil {
-			// Validate file size matches metadata
-			if info.Size() != expectedSize {
-				// Cache corrupted, remove it
-				log := logger.Get()
-				log.Warnf("Cache size mismatch: %s (expected %d, got %d). Removing corrupted cache.", cachePath, expectedSize, info.Size())
-				os.Remove(cachePath)
-				os.Remove(metaPath)
-				return false
-			}
-		}
+	// A .parts sidecar means the file is still being assembled from Range
+	// requests (see StreamRange) and isn't safe to serve as a complete
+	// entry yet.
+	if _, err := os.Stat(partsPath(cachePath)); err == nil {
+		return false
+	}
+
+	// Check if metadata file exists and matches. Only the size is checked
+	// here, on every cache hit; the SHA256 recorded alongside it is instead
+	// verified by the background scrubber (see ScrubOnce), since hashing the
+	// whole file on every request would be far too expensive.
+	if expectedSize, ok := readMetaSize(cachePath); ok && info.Size() != expectedSize {
+		log := logger.Get()
+		log.Warnf("Cache size mismatch: %s (expected %d, got %d). Removing corrupted cache.", cachePath, expectedSize, info.Size())
+		os.Remove(cachePath)
+		os.Remove(cachePath + ".meta")
+		return false
 	}
 
 	cfg := config.Get()
@@ -121,52 +190,257 @@ func IsCacheValid(cachePath string) bool {
 	return age < maxAge
 }
 
-// StreamAndCache downloads from upstream and caches the file while streaming to client
-func StreamAndCache(urls []string, cachePath string, headers map[string]string) (*http.Response, error) {
+// readMetaSize reads the expected size out of cachePath's .meta sidecar,
+// understanding both the current JSON record and the plain "%d\n" format
+// written before bitrot protection existed.
+func readMetaSize(cachePath string) (int64, bool) {
+	data, err := os.ReadFile(cachePath + ".meta")
+	if err != nil {
+		return 0, false
+	}
+
+	var rec Meta
+	if json.Unmarshal(data, &rec) == nil && rec.Size > 0 {
+		return rec.Size, true
+	}
+
+	var legacySize int64
+	if _, err := fmt.Sscanf(string(data), "%d", &legacySize); err == nil {
+		return legacySize, true
+	}
+	return 0, false
+}
+
+// cachedFetch is the result of coalescedFetch: everything a caller needs to
+// open its own response on the now-complete cache file.
+type cachedFetch struct {
+	statusCode int
+	header     http.Header
+	mirror     string
+}
+
+// StreamAndCache downloads from upstream and caches the file, coalescing
+// concurrent requests for the same cachePath through the inflight registry
+// (see inflight.go) so only one of them reaches the upstream mirrors. The
+// first caller for a cachePath runs the download and live-streams progress;
+// every other caller tails the same download as it arrives instead of
+// blocking until it's complete. distro/pkgPath are only used to cross-check
+// the download against a SHA256 already published in a Packages index, if
+// any (see verifyAgainstKnownHash and commitToPool).
+func StreamAndCache(targets []mirrors.FetchTarget, cachePath string, headers map[string]string, distro, pkgPath string) (*http.Response, string, error) {
+	d, leader := joinOrStartInflight(cachePath)
+	if leader {
+		return runLeader(d, targets, cachePath, headers, distro, pkgPath)
+	}
+
+	resp, mirror, err := followInflight(d)
+	if err == nil {
+		return resp, mirror, nil
+	}
+
+	// The leader failed before ever producing a response (or we couldn't
+	// open its temp file) - fetch independently instead of handing the
+	// client an error for a file that a retry might still be able to serve.
+	logger.Get().Warnf("Follower for %s couldn't use in-progress download (%v), fetching independently", cachePath, err)
+	return independentFetch(targets, cachePath, headers, distro, pkgPath)
+}
+
+// runLeader performs the actual download for cachePath. Only the first
+// mirror attempt gets live progress reported to d - if it fails, followers
+// are told to retry on their own (via independentFetch) rather than the
+// leader silently moving on to a second mirror underneath readers that may
+// already be tailing the (about to be truncated) temp file.
+func runLeader(d *inflightDownload, targets []mirrors.FetchTarget, cachePath string, headers map[string]string, distro, pkgPath string) (*http.Response, string, error) {
+	defer clearInflight(cachePath)
 	log := logger.Get()
-	
+
+	if _, err := os.Stat(d.tempPath); err == nil {
+		os.Remove(d.tempPath)
+	}
+
+	ordered := mirrors.OrderForFetch(targets)
+	if len(ordered) == 0 {
+		err := fmt.Errorf("no mirrors configured")
+		d.finish(err)
+		return nil, "", err
+	}
+
+	target := ordered[0]
+	start := time.Now()
+	resp, err := downloadAndCache(target, cachePath, headers, distro, pkgPath, d)
+	elapsed := time.Since(start)
+	metrics.ObserveUpstreamLatency(target.Mirror, elapsed.Seconds())
+
+	if err != nil {
+		mirrors.RecordFetchResult(target.Mirror, false, elapsed)
+		metrics.RecordMirrorFetch(target.Mirror, "failure")
+		log.Warnf("Leader's mirror attempt failed for %s, remaining followers retrying independently: %v", cachePath, err)
+		d.finish(err)
+		return independentFetch(targets, cachePath, headers, distro, pkgPath)
+	}
+
+	d.setHeader(resp.StatusCode, resp.Header, target.URL)
+
+	_, copyErr := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if copyErr != nil {
+		mirrors.RecordFetchResult(target.Mirror, false, elapsed)
+		metrics.RecordMirrorFetch(target.Mirror, "failure")
+		log.Warnf("Leader's download failed mid-transfer for %s, retrying remaining mirrors independently: %v", cachePath, copyErr)
+		d.finish(copyErr)
+		return independentFetch(targets, cachePath, headers, distro, pkgPath)
+	}
+
+	mirrors.RecordFetchResult(target.Mirror, true, elapsed)
+	metrics.RecordMirrorFetch(target.Mirror, "success")
+	d.finish(nil)
+
+	respFile, err := createResponseFromFile(cachePath, resp.StatusCode, resp.Header)
+	if err != nil {
+		return nil, target.URL, err
+	}
+	return respFile, target.URL, nil
+}
+
+// independentFetch is the non-live-streamed fallback: it tries every mirror
+// in turn (see coalescedFetch) and only returns once the whole file is
+// cached, same as StreamAndCache's behavior before followers could tail an
+// in-progress leader. Used when a follower can't attach to the current
+// leader at all.
+func independentFetch(targets []mirrors.FetchTarget, cachePath string, headers map[string]string, distro, pkgPath string) (*http.Response, string, error) {
+	fetched, err := coalescedFetch(targets, cachePath, headers, distro, pkgPath)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := createResponseFromFile(cachePath, fetched.statusCode, fetched.header)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, fetched.mirror, nil
+}
+
+// StreamPassthrough fetches from the first working mirror and hands back its
+// live response body, without writing anything to disk. Used instead of
+// StreamAndCache for URLs that haven't yet crossed the cfg.CacheAfter
+// admission threshold (see RecordAccess), so a one-shot download doesn't
+// pollute the cache. Unlike StreamAndCache, concurrent callers are not
+// coalesced - each one independently hits upstream, matching the threshold
+// policy's goal of treating rare URLs as not worth the coordination either.
+func StreamPassthrough(targets []mirrors.FetchTarget, headers map[string]string) (*http.Response, string, error) {
+	log := logger.Get()
+
+	var lastErr error
+	errorCount := 0
+	ordered := mirrors.OrderForFetch(targets)
+
+	for _, target := range ordered {
+		start := time.Now()
+		resp, err := fetchPassthrough(target.URL, target.Mirror, headers)
+		elapsed := time.Since(start)
+		metrics.ObserveUpstreamLatency(target.Mirror, elapsed.Seconds())
+		if err == nil {
+			mirrors.RecordFetchResult(target.Mirror, true, elapsed)
+			metrics.RecordMirrorFetch(target.Mirror, "success")
+			return resp, target.URL, nil
+		}
+		mirrors.RecordFetchResult(target.Mirror, false, elapsed)
+		metrics.RecordMirrorFetch(target.Mirror, "failure")
+		lastErr = err
+		errorCount++
+
+		if errorCount == 1 || errorCount == len(ordered) {
+			log.Warnf("Mirror failed (%d/%d): %v", errorCount, len(ordered), err)
+		}
+	}
+
+	return nil, "", fmt.Errorf("all %d mirrors failed: %v", len(ordered), lastErr)
+}
+
+func fetchPassthrough(url, mirror string, headers map[string]string) (*http.Response, error) {
+	if refused, forcedStatus := chaos.BeforeFetch(mirror); refused {
+		return nil, fmt.Errorf("connection refused (chaos injected)")
+	} else if forcedStatus != 0 {
+		return nil, fmt.Errorf("HTTP %d (chaos injected)", forcedStatus)
+	}
+	return fetchUpstream(url, headers)
+}
+
+// coalescedFetch tries every mirror in turn and only returns once the whole
+// file is cached - no live progress reporting, since its callers
+// (independentFetch) don't have followers tailing them.
+func coalescedFetch(targets []mirrors.FetchTarget, cachePath string, headers map[string]string, distro, pkgPath string) (*cachedFetch, error) {
+	log := logger.Get()
+
 	// Clean up any leftover temp file from previous failed download
 	tempPath := cachePath + ".tmp"
 	if _, err := os.Stat(tempPath); err == nil {
 		os.Remove(tempPath)
 	}
-	
+
 	var lastErr error
 	errorCount := 0
-	
-	// Try each URL until one succeeds
-	for _, url := range urls {
-		resp, err := downloadAndCache(url, cachePath, headers)
+
+	// Order by health before each fetch so a mirror that's cooling down from
+	// recent failures doesn't eat a retry slot on every miss.
+	ordered := mirrors.OrderForFetch(targets)
+
+	// Try each target until one succeeds
+	for _, target := range ordered {
+		start := time.Now()
+		resp, err := downloadAndCache(target, cachePath, headers, distro, pkgPath, nil)
+		elapsed := time.Since(start)
+		metrics.ObserveUpstreamLatency(target.Mirror, elapsed.Seconds())
 		if err == nil {
-			return resp, nil
+			fetched := &cachedFetch{statusCode: resp.StatusCode, header: resp.Header, mirror: target.URL}
+			_, copyErr := io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if copyErr != nil {
+				mirrors.RecordFetchResult(target.Mirror, false, elapsed)
+				metrics.RecordMirrorFetch(target.Mirror, "failure")
+				lastErr = copyErr
+				errorCount++
+				continue
+			}
+			mirrors.RecordFetchResult(target.Mirror, true, elapsed)
+			metrics.RecordMirrorFetch(target.Mirror, "success")
+			return fetched, nil
 		}
+		mirrors.RecordFetchResult(target.Mirror, false, elapsed)
+		metrics.RecordMirrorFetch(target.Mirror, "failure")
 		lastErr = err
 		errorCount++
-		
+
 		// Only log first and last errors to reduce noise
-		if errorCount == 1 || errorCount == len(urls) {
-			log.Warnf("Mirror failed (%d/%d): %v", errorCount, len(urls), err)
+		if errorCount == 1 || errorCount == len(ordered) {
+			log.Warnf("Mirror failed (%d/%d): %v", errorCount, len(ordered), err)
 		}
 	}
-	
-	return nil, fmt.Errorf("all %d mirrors failed: %v", len(urls), lastErr)
+
+	return nil, fmt.Errorf("all %d mirrors failed: %v", len(ordered), lastErr)
 }
 
 // streamingReader wraps the response body to write to cache file while reading
 type streamingReader struct {
-	resp          *http.Response
-	file          *os.File
-	tempPath      string
-	finalPath     string
-	teeReader     io.Reader
-	closed        bool
-	expectedSize  int64
-	writtenBytes  int64
+	resp         *http.Response
+	file         *os.File
+	tempPath     string
+	finalPath    string
+	teeReader    io.Reader
+	hasher       hash.Hash
+	closed       bool
+	expectedSize int64
+	writtenBytes int64
+	distro       string
+	pkgPath      string
+	progress     *inflightDownload
 }
 
 func (sr *streamingReader) Read(p []byte) (n int, err error) {
 	n, err = sr.teeReader.Read(p)
 	sr.writtenBytes += int64(n)
+	if sr.progress != nil && n > 0 {
+		sr.progress.reportProgress(int64(n))
+	}
 	return n, err
 }
 
@@ -207,14 +481,35 @@ func (sr *streamingReader) Close() error {
 			return nil
 		}
 		
-		// Save metadata with expected size for validation during cache hit
+		// Save metadata - size for the cheap validity check on every cache
+		// hit, plus the SHA256 the scrubber verifies in the background and
+		// the upstream headers apt needs for conditional GETs on a hit.
 		metaPath := sr.finalPath + ".meta"
-		metaFile, err := os.Create(metaPath)
-		if err == nil {
-			fmt.Fprintf(metaFile, "%d\n", info.Size())
-			metaFile.Close()
+		rec := Meta{
+			Size:         info.Size(),
+			ContentType:  sr.resp.Header.Get("Content-Type"),
+			ETag:         sr.resp.Header.Get("ETag"),
+			LastModified: sr.resp.Header.Get("Last-Modified"),
+			CachedAt:     time.Now(),
 		}
-		
+		if sr.hasher != nil {
+			rec.SHA256 = hex.EncodeToString(sr.hasher.Sum(nil))
+		}
+
+		// If the distro's Packages index already told us what this file's
+		// SHA256 should be, reject a mismatch here rather than caching (and
+		// later serving) corrupted bytes - cheaper and earlier than waiting
+		// for the background scrubber to catch it.
+		if rec.SHA256 != "" && !verifyAgainstKnownHash(sr.distro, sr.pkgPath, rec.SHA256) {
+			log.Warnf("Downloaded %s doesn't match SHA256 published in Packages index, discarding", sr.finalPath)
+			os.Remove(sr.tempPath)
+			return nil
+		}
+
+		if data, err := json.Marshal(rec); err == nil {
+			os.WriteFile(metaPath, data, 0644)
+		}
+
 		// Atomic rename - only cache if download was complete
 		if err := os.Rename(sr.tempPath, sr.finalPath); err != nil {
 			log.Warnf("Failed to cache file: %v", err)
@@ -222,50 +517,74 @@ func (sr *streamingReader) Close() error {
 			os.Remove(metaPath)
 		} else {
 			log.Infof("Cached: %s (%d bytes)", sr.finalPath, info.Size())
+			if rec.SHA256 != "" {
+				commitToPool(sr.finalPath, rec.SHA256)
+			}
 		}
 	}
 	
 	return nil
 }
 
-func downloadAndCache(url, cachePath string, headers map[string]string) (*http.Response, error) {
-	log := logger.Get()
-	log.Infof("Downloading: %s", url)
-	
+// fetchUpstream issues a GET against url with headers forwarded from the
+// client (minus Host), following redirects, and normalizes DNS failures to a
+// message downloadAndCache/coalescedFetch already know how to report. Shared
+// by downloadAndCache and StreamPassthrough so both paths fail the same way.
+func fetchUpstream(url string, headers map[string]string) (*http.Response, error) {
 	client := &http.Client{
 		Timeout: 120 * time.Second, // Increased timeout for large files
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return nil // Follow redirects
 		},
 	}
-	
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// Copy headers
 	for k, v := range headers {
 		if strings.ToLower(k) != "host" {
 			req.Header.Set(k, v)
 		}
 	}
-	
+
 	resp, err := client.Do(req)
 	if err != nil {
 		// Check for DNS errors
-		if strings.Contains(err.Error(), "no such host") || 
-		   strings.Contains(err.Error(), "Temporary failure in name resolution") {
+		if strings.Contains(err.Error(), "no such host") ||
+			strings.Contains(err.Error(), "Temporary failure in name resolution") {
 			return nil, fmt.Errorf("DNS resolution failed")
 		}
 		return nil, err
 	}
-	
+
 	if resp.StatusCode >= 400 {
 		resp.Body.Close()
 		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
-	
+
+	return resp, nil
+}
+
+func downloadAndCache(target mirrors.FetchTarget, cachePath string, headers map[string]string, distro, pkgPath string, progress *inflightDownload) (*http.Response, error) {
+	log := logger.Get()
+	url := target.URL
+
+	if refused, forcedStatus := chaos.BeforeFetch(target.Mirror); refused {
+		return nil, fmt.Errorf("connection refused (chaos injected)")
+	} else if forcedStatus != 0 {
+		return nil, fmt.Errorf("HTTP %d (chaos injected)", forcedStatus)
+	}
+
+	log.Infof("Downloading: %s", url)
+
+	resp, err := fetchUpstream(url, headers)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create temp file for atomic write
 	tempPath := cachePath + ".tmp"
 	file, err := os.Create(tempPath)
@@ -273,16 +592,34 @@ func downloadAndCache(url, cachePath string, headers map[string]string) (*http.R
 		resp.Body.Close()
 		return nil, err
 	}
-	
-	// Create streaming reader that writes to cache while being read
+
+	// Simulate a mirror that drops the connection partway through a
+	// transfer, if configured (see internal/chaos).
+	body := io.Reader(resp.Body)
+	if chaos.ShouldTruncate(target.Mirror) {
+		limit := resp.ContentLength / 2
+		if limit <= 0 {
+			limit = 32 * 1024
+		}
+		log.Warnf("Chaos: truncating %s after %d bytes", url, limit)
+		body = chaos.NewTruncatingReader(resp.Body, limit)
+	}
+
+	// Create streaming reader that writes to cache while being read, hashing
+	// as it goes so Close can record a digest for bitrot detection
+	hasher := sha256.New()
 	sr := &streamingReader{
 		resp:         resp,
 		file:         file,
 		tempPath:     tempPath,
 		finalPath:    cachePath,
-		teeReader:    io.TeeReader(resp.Body, file),
+		hasher:       hasher,
+		teeReader:    io.TeeReader(body, io.MultiWriter(file, hasher)),
 		expectedSize: resp.ContentLength,
 		writtenBytes: 0,
+		distro:       distro,
+		pkgPath:      pkgPath,
+		progress:     progress,
 	}
 	
 	// Create new response with streaming reader
@@ -357,9 +694,9 @@ func CleanOldCache() error {
 		// Check if file is older than cutoff
 		if info.ModTime().Before(cutoffTime) {
 			if err := os.Remove(path); err == nil {
-				// Also remove metadata file if it exists
-				metaPath := path + ".meta"
-				os.Remove(metaPath)
+				// Also remove the metadata/parts sidecars, if any
+				os.Remove(path + ".meta")
+				os.Remove(partsPath(path))
 				cleanedCount++
 			}
 		}
@@ -385,49 +722,68 @@ func DeleteCachedFile(path string) error {
 		return fmt.Errorf("invalid path: outside storage directory")
 	}
 	
-	// Also remove metadata file
-	metaPath := absPath + ".meta"
-	os.Remove(metaPath)
-	
+	// Also remove the metadata/parts sidecars, if any
+	os.Remove(absPath + ".meta")
+	os.Remove(partsPath(absPath))
+
 	return os.Remove(absPath)
 }
 
-// AddBlacklistPattern adds a pattern to the blacklist
+// AddBlacklistPattern adds a pattern to the blacklist. Routed through Raft on
+// a cluster so every node shares the same blacklist.
 func AddBlacklistPattern(pattern string) error {
+	return cluster.Apply("blacklist.add", blacklistCmd{Pattern: pattern})
+}
+
+func applyAddBlacklistCmd(payload []byte) error {
+	var cmd blacklistCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
 	db := database.Get()
-	_, err := db.Exec("INSERT OR IGNORE INTO package_blacklist (pattern) VALUES (?)", pattern)
+	_, err := db.Exec("INSERT OR IGNORE INTO package_blacklist (pattern) VALUES (?)", cmd.Pattern)
 	if err != nil {
 		return err
 	}
-	
+
 	blacklistMu.Lock()
-	blacklistPatterns = append(blacklistPatterns, pattern)
+	blacklistPatterns = append(blacklistPatterns, cmd.Pattern)
 	blacklistMu.Unlock()
-	
+
 	log := logger.Get()
-	log.Infof("Added blacklist pattern: %s", pattern)
+	log.Infof("Added blacklist pattern: %s", cmd.Pattern)
 	return nil
 }
 
 // RemoveBlacklistPattern removes a pattern from the blacklist
 func RemoveBlacklistPattern(pattern string) error {
+	return cluster.Apply("blacklist.remove", blacklistCmd{Pattern: pattern})
+}
+
+func applyRemoveBlacklistCmd(payload []byte) error {
+	var cmd blacklistCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
 	db := database.Get()
-	_, err := db.Exec("DELETE FROM package_blacklist WHERE pattern = ?", pattern)
+	_, err := db.Exec("DELETE FROM package_blacklist WHERE pattern = ?", cmd.Pattern)
 	if err != nil {
 		return err
 	}
-	
+
 	blacklistMu.Lock()
 	for i, p := range blacklistPatterns {
-		if p == pattern {
+		if p == cmd.Pattern {
 			blacklistPatterns = append(blacklistPatterns[:i], blacklistPatterns[i+1:]...)
 			break
 		}
 	}
 	blacklistMu.Unlock()
-	
+
 	log := logger.Get()
-	log.Infof("Removed blacklist pattern: %s", pattern)
+	log.Infof("Removed blacklist pattern: %s", cmd.Pattern)
 	return nil
 }
 
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// inflightDownload coordinates one in-progress cachePath download so
+// concurrent requests for the same cold file don't each trigger their own
+// upstream fetch (thundering herd right after an `apt update`). The first
+// caller becomes the leader and runs the real download; every other caller
+// attaches as a follower and tails the leader's temp file as bytes arrive
+// instead of blocking until the whole thing finishes - a multi-hundred-MB
+// .deb no longer means every follower waits as long as the slowest mirror
+// before seeing a single byte.
+type inflightDownload struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	tempPath    string
+	written     int64
+	headerReady bool
+	status      int
+	header      http.Header
+	mirror      string
+	done        bool
+	err         error
+}
+
+func newInflightDownload(tempPath string) *inflightDownload {
+	d := &inflightDownload{tempPath: tempPath}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// reportProgress records that n more bytes have been written to tempPath and
+// wakes any followers blocked waiting for them.
+func (d *inflightDownload) reportProgress(n int64) {
+	d.mu.Lock()
+	d.written += n
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+// setHeader publishes the upstream status/headers as soon as the leader has
+// them (before the body has finished streaming), so followers can start
+// building their own response instead of waiting for completion.
+func (d *inflightDownload) setHeader(status int, header http.Header, mirror string) {
+	d.mu.Lock()
+	d.headerReady = true
+	d.status = status
+	d.header = header
+	d.mirror = mirror
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+// finish marks the download as complete - successfully or not - and wakes
+// every follower still waiting, so a tailing Read returns EOF or a follower
+// that never got a header can fall back to its own fetch.
+func (d *inflightDownload) finish(err error) {
+	d.mu.Lock()
+	d.done = true
+	d.err = err
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+var (
+	inflightMu     sync.Mutex
+	inflightByPath = map[string]*inflightDownload{}
+)
+
+// joinOrStartInflight registers the caller as the leader for cachePath if no
+// download is currently in progress for it, or as a follower of the
+// existing one otherwise.
+func joinOrStartInflight(cachePath string) (d *inflightDownload, leader bool) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+
+	if existing, ok := inflightByPath[cachePath]; ok {
+		return existing, false
+	}
+
+	d = newInflightDownload(cachePath + ".tmp")
+	inflightByPath[cachePath] = d
+	return d, true
+}
+
+func clearInflight(cachePath string) {
+	inflightMu.Lock()
+	delete(inflightByPath, cachePath)
+	inflightMu.Unlock()
+}
+
+// followInflight waits for the leader tracked by d to publish a header, then
+// returns a response backed by a followerReader tailing its temp file. If
+// the leader fails before ever getting a response from upstream, it returns
+// that error so StreamAndCache can fall back to an independent fetch instead
+// of handing the client a broken response.
+func followInflight(d *inflightDownload) (*http.Response, string, error) {
+	d.mu.Lock()
+	for !d.headerReady && !d.done {
+		d.cond.Wait()
+	}
+	if !d.headerReady {
+		err := d.err
+		d.mu.Unlock()
+		return nil, "", err
+	}
+	status, header, mirror := d.status, d.header, d.mirror
+	d.mu.Unlock()
+
+	f, err := os.Open(d.tempPath)
+	if err != nil {
+		return nil, mirror, err
+	}
+
+	respHeader := make(http.Header, len(header))
+	for k, v := range header {
+		respHeader[k] = append([]string(nil), v...)
+	}
+
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     respHeader,
+		Body:       &followerReader{d: d, f: f},
+	}
+	return resp, mirror, nil
+}
+
+// followerReader streams bytes out of an in-progress download's temp file as
+// the leader writes them, blocking on d.cond when it catches up instead of
+// returning a premature EOF. If the leader ends up failing mid-transfer, a
+// follower already tailing it gets a read error terminating its response -
+// by that point its own client has already received headers (and possibly
+// some body bytes), so a transparent retry isn't possible; this is the same
+// failure mode as the upstream mirror itself dropping the connection.
+type followerReader struct {
+	d    *inflightDownload
+	f    *os.File
+	read int64
+}
+
+func (fr *followerReader) Read(p []byte) (int, error) {
+	d := fr.d
+
+	d.mu.Lock()
+	for d.written <= fr.read && !d.done {
+		d.cond.Wait()
+	}
+	avail := d.written - fr.read
+	failed := d.err != nil
+	d.mu.Unlock()
+
+	if avail <= 0 {
+		if failed {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, io.EOF
+	}
+
+	n, err := fr.f.Read(p)
+	fr.read += int64(n)
+	if err == io.EOF {
+		// Caught up with what's on disk so far - the leader may still be
+		// writing more, so this isn't really EOF yet.
+		err = nil
+	}
+	return n, err
+}
+
+func (fr *followerReader) Close() error {
+	return fr.f.Close()
+}
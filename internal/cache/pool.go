@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/debidx"
+	"apt-cache-proxy/internal/logger"
+)
+
+// poolPath returns the content-addressed location for a blob with the given
+// SHA256, sharded by the first byte of the hash the same way GetCachePath
+// shards by the MD5 of pkgPath.
+func poolPath(sha256Hex string) string {
+	cfg := config.Get()
+	return filepath.Join(cfg.StoragePathResolved, "pool", sha256Hex[:2], sha256Hex)
+}
+
+// TryDedupeFromPool checks whether pkgPath's SHA256 is already known (from a
+// Packages index ingested via debidx.Ingest) and, if a pool blob for that
+// hash is already on disk, hardlinks cachePath to it instead of letting the
+// caller fall through to an upstream download. This is what lets the same
+// .deb appearing under different mirror paths or distro codenames (common on
+// Ubuntu/Debian mirrors carrying overlapping packages) be served from a
+// single copy on disk. Returns true if cachePath was populated this way.
+func TryDedupeFromPool(distro, pkgPath, cachePath string) bool {
+	sha256Hex, size, ok := debidx.LookupByFilename(distro, pkgPath)
+	if !ok || len(sha256Hex) != 64 {
+		return false
+	}
+
+	pool := poolPath(sha256Hex)
+	if _, err := os.Stat(pool); err != nil {
+		return false
+	}
+
+	log := logger.Get()
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		log.Warnf("Failed to create cache dir for pool dedupe of %s: %v", cachePath, err)
+		return false
+	}
+	if err := os.Link(pool, cachePath); err != nil {
+		log.Warnf("Failed to hardlink %s from pool: %v", cachePath, err)
+		return false
+	}
+
+	meta := Meta{Size: size, SHA256: sha256Hex, CachedAt: time.Now()}
+	if data, err := json.Marshal(meta); err == nil {
+		os.WriteFile(cachePath+".meta", data, 0644)
+	}
+
+	log.Infof("Deduped %s from pool (sha256=%s)", cachePath, sha256Hex)
+	return true
+}
+
+// commitToPool adds finalPath to the content-addressed pool under its known
+// SHA256 once a download completes (see streamingReader.Close), so later
+// requests for the same content under a different cachePath can be deduped
+// by TryDedupeFromPool. If a pool entry for this hash already exists -
+// another URL turned out to carry identical bytes - finalPath is relinked to
+// it instead of keeping two on-disk copies of the same content.
+func commitToPool(finalPath, sha256Hex string) {
+	if len(sha256Hex) != 64 {
+		return
+	}
+	log := logger.Get()
+	pool := poolPath(sha256Hex)
+
+	if _, err := os.Stat(pool); err == nil {
+		if os.Remove(finalPath) != nil {
+			return
+		}
+		if err := os.Link(pool, finalPath); err != nil {
+			log.Warnf("Failed to hardlink %s from pool: %v", finalPath, err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pool), 0755); err != nil {
+		log.Warnf("Failed to create pool dir for %s: %v", sha256Hex, err)
+		return
+	}
+	if err := os.Link(finalPath, pool); err != nil {
+		log.Warnf("Failed to add %s to pool: %v", finalPath, err)
+	}
+}
+
+// verifyAgainstKnownHash reports whether computedSHA256 agrees with the
+// SHA256 already published for distro/pkgPath in a Packages index (ingested
+// via debidx.Ingest), and true when no hash is known. A mismatch means the mirror served something other than
+// what the index promised - corrupted in transit or tampered with - and the
+// download should be rejected before it's committed to the cache.
+func verifyAgainstKnownHash(distro, pkgPath, computedSHA256 string) bool {
+	expected, _, ok := debidx.LookupByFilename(distro, pkgPath)
+	if !ok {
+		return true
+	}
+	return expected == computedSHA256
+}
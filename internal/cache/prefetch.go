@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/database"
+	"apt-cache-proxy/internal/debidx"
+	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/mirrors"
+)
+
+// EnqueuePrefetch records newly-indexed packages (as reported by
+// debidx.IngestAndDiff) as candidates for the background prefetch worker
+// (RunPrefetchBatch, driven by internal/worker's cachePrefetcher). Packages
+// without a Filename/SHA256, blacklisted, or for an architecture not in
+// Config.PrefetchArchitectures are skipped. Whether a package is already
+// cached isn't checked here; RunPrefetchBatch marks such entries done
+// without fetching them.
+func EnqueuePrefetch(distro, suite string, pkgs []debidx.Package) {
+	cfg := config.Get()
+	if !cfg.PrefetchEnabled || len(pkgs) == 0 {
+		return
+	}
+
+	allowedArches := cfg.PrefetchArchitectures[distro]
+	if len(allowedArches) == 0 {
+		return
+	}
+
+	db := database.Get()
+	log := logger.Get()
+
+	for _, pkg := range pkgs {
+		if pkg.Filename == "" || pkg.SHA256 == "" {
+			continue
+		}
+		if !containsString(allowedArches, pkg.Architecture) {
+			continue
+		}
+		if IsBlacklisted(pkg.Filename) {
+			continue
+		}
+
+		if _, err := db.Exec(`INSERT OR IGNORE INTO prefetch_queue
+			(distro, suite, filename, architecture, sha256, size, status)
+			VALUES (?, ?, ?, ?, ?, ?, 'pending')`,
+			distro, suite, pkg.Filename, pkg.Architecture, pkg.SHA256, pkg.Size); err != nil {
+			log.Warnf("prefetch: failed to queue %s: %v", pkg.Filename, err)
+		}
+	}
+}
+
+// containsString is a small linear-search helper - the architecture
+// allowlists involved here are short enough (a handful of entries) that a
+// map isn't worth the extra bookkeeping.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// inPrefetchWindow reports whether now falls within the configured off-peak
+// prefetch window. Equal start/end hours mean "always on" (config.reload
+// turns an unset 0/0 into a 2-6 window before it gets here).
+func inPrefetchWindow(cfg *config.Config, now time.Time) bool {
+	if cfg.PrefetchWindowStartHour == cfg.PrefetchWindowEndHour {
+		return true
+	}
+
+	hour := now.Hour()
+	if cfg.PrefetchWindowStartHour < cfg.PrefetchWindowEndHour {
+		return hour >= cfg.PrefetchWindowStartHour && hour < cfg.PrefetchWindowEndHour
+	}
+	// Window wraps past midnight, e.g. 22-6.
+	return hour >= cfg.PrefetchWindowStartHour || hour < cfg.PrefetchWindowEndHour
+}
+
+// RunPrefetchBatch pops up to Config.PrefetchBatchSize pending prefetch_queue
+// entries and fetches each through the normal cache path, same as the
+// /admin/prefetch endpoint. Called on a timer by internal/worker's
+// cachePrefetcher; a no-op outside the configured off-peak window or while
+// paused.
+func RunPrefetchBatch() error {
+	cfg := config.Get()
+	if !cfg.PrefetchEnabled || cfg.PrefetchPaused {
+		return nil
+	}
+	if !inPrefetchWindow(cfg, time.Now()) {
+		return nil
+	}
+
+	db := database.Get()
+	log := logger.Get()
+
+	rows, err := db.Query(`SELECT distro, suite, filename FROM prefetch_queue
+		WHERE status = 'pending' ORDER BY queued_at LIMIT ?`, cfg.PrefetchBatchSize)
+	if err != nil {
+		return err
+	}
+
+	type entry struct{ distro, suite, filename string }
+	var batch []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.distro, &e.suite, &e.filename); err != nil {
+			continue
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+
+	allMirrors := mirrors.GetAll()
+
+	for _, e := range batch {
+		mirrorURLs, ok := allMirrors[mirrors.GetUpstreamKey(e.distro, e.filename)]
+		if !ok {
+			mirrorURLs, ok = allMirrors[e.distro]
+		}
+		if !ok {
+			markPrefetchStatus(e.distro, e.suite, e.filename, "failed")
+			continue
+		}
+
+		cachePath := GetCachePath(e.distro, e.filename)
+		if !IsCacheValid(cachePath) {
+			TryDedupeFromPool(e.distro, e.filename, cachePath)
+		}
+		if IsCacheValid(cachePath) {
+			markPrefetchStatus(e.distro, e.suite, e.filename, "done")
+			continue
+		}
+
+		targets := make([]mirrors.FetchTarget, len(mirrorURLs))
+		for i, m := range mirrorURLs {
+			targets[i] = mirrors.FetchTarget{
+				URL:    fmt.Sprintf("%s/%s", strings.TrimSuffix(m, "/"), e.filename),
+				Mirror: m,
+			}
+		}
+
+		resp, _, err := StreamAndCache(targets, cachePath, nil, e.distro, e.filename)
+		if err != nil {
+			log.Warnf("prefetch: failed to fetch %s: %v", e.filename, err)
+			markPrefetchStatus(e.distro, e.suite, e.filename, "failed")
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		log.Infof("prefetch: fetched %s", e.filename)
+		markPrefetchStatus(e.distro, e.suite, e.filename, "done")
+	}
+
+	return nil
+}
+
+// PrefetchQueueEntry is a single queued-or-processed prefetch candidate, as
+// returned by ListPrefetchQueue.
+type PrefetchQueueEntry struct {
+	Distro       string `json:"distro"`
+	Suite        string `json:"suite"`
+	Filename     string `json:"filename"`
+	Architecture string `json:"architecture"`
+	Size         int64  `json:"size"`
+	Status       string `json:"status"`
+	QueuedAt     string `json:"queued_at"`
+}
+
+// ListPrefetchQueue returns the most recently queued prefetch_queue rows
+// (any status), newest first, for the admin UI.
+func ListPrefetchQueue(limit int) ([]PrefetchQueueEntry, error) {
+	db := database.Get()
+
+	rows, err := db.Query(`SELECT distro, suite, filename, architecture, size, status, queued_at
+		FROM prefetch_queue ORDER BY queued_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []PrefetchQueueEntry{}
+	for rows.Next() {
+		var e PrefetchQueueEntry
+		if err := rows.Scan(&e.Distro, &e.Suite, &e.Filename, &e.Architecture, &e.Size, &e.Status, &e.QueuedAt); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func markPrefetchStatus(distro, suite, filename, status string) {
+	db := database.Get()
+	db.Exec(`UPDATE prefetch_queue SET status = ?, fetched_at = CURRENT_TIMESTAMP
+		WHERE distro = ? AND suite = ? AND filename = ?`, status, distro, suite, filename)
+}
@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/mirrors"
+)
+
+var (
+	partsLocksMu sync.Mutex
+	partsLocks   = map[string]*sync.Mutex{}
+)
+
+// lockParts serializes access to cachePath's .parts sidecar across concurrent
+// StreamRange calls for different byte windows of the same cold file.
+// Without this, two goroutines each read the sidecar, fetch their own
+// window, and write back their own in-memory partsRecord - whichever writes
+// last silently drops the other's recorded range, leaving Ranges permanently
+// out of sync with what's actually on disk. Callers must defer the returned
+// unlock func.
+func lockParts(cachePath string) func() {
+	partsLocksMu.Lock()
+	l, ok := partsLocks[cachePath]
+	if !ok {
+		l = &sync.Mutex{}
+		partsLocks[cachePath] = l
+	}
+	partsLocksMu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// ParsedRange is a single-range client request, e.g. "bytes=500-999"
+// (End == 999) or "bytes=500-" (End == -1, meaning to the end of the
+// resource).
+type ParsedRange struct {
+	Start int64
+	End   int64
+}
+
+// ParseRangeHeader parses a single-range HTTP Range header. Multi-range
+// requests ("bytes=0-99,200-299") and suffix ranges ("bytes=-500") return an
+// error - the sparse cache layout in StreamRange only tracks one interval
+// list per file, and both forms are rare enough from apt/dpkg clients that
+// falling back to an ordinary full fetch (see proxy.handlePackage) is an
+// acceptable trade-off.
+func ParseRangeHeader(header string) (ParsedRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return ParsedRange{}, fmt.Errorf("unsupported range unit: %s", header)
+	}
+	spec := strings.TrimPrefix(header, prefix)
+	if strings.Contains(spec, ",") {
+		return ParsedRange{}, fmt.Errorf("multi-range requests not supported")
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ParsedRange{}, fmt.Errorf("unsupported range: %s", header)
+	}
+
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ParsedRange{}, err
+	}
+	if parts[1] == "" {
+		return ParsedRange{Start: start, End: -1}, nil
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ParsedRange{}, err
+	}
+	return ParsedRange{Start: start, End: end}, nil
+}
+
+// rangeReadCloser bounds reads to a requested byte range while still closing
+// the underlying file handle.
+type rangeReadCloser struct {
+	r io.Reader
+	f *os.File
+}
+
+func (rc *rangeReadCloser) Read(p []byte) (int, error) { return rc.r.Read(p) }
+func (rc *rangeReadCloser) Close() error               { return rc.f.Close() }
+
+// StreamRange serves [reqRange.Start, end] of cachePath for a single-range
+// client GET, fetching whatever bytes aren't already cached from upstream
+// (via the same Range header against the mirror) and recording progress in
+// cachePath+".parts" so a restart or a later client resumes instead of
+// re-downloading. It bypasses the inflight-download coordination StreamAndCache
+// uses (see inflight.go): unlike a full download, two callers can legitimately
+// want different windows of the same file, so coalescing them into one
+// in-flight fetch doesn't make sense - but the read-modify-write of the
+// shared .parts sidecar itself is still serialized per cachePath (see
+// lockParts), so two concurrent windows can't clobber each other's recorded
+// range. Returns the requested bytes, the resource's total size, its
+// Content-Type, and which mirror served it (for access logging).
+func StreamRange(targets []mirrors.FetchTarget, cachePath string, headers map[string]string, reqRange ParsedRange) (io.ReadCloser, int64, string, string, error) {
+	unlock := lockParts(cachePath)
+
+	rec, err := readParts(cachePath)
+	if err != nil {
+		rec = &partsRecord{Size: -1}
+	}
+
+	var contentType, mirror string
+
+	end := reqRange.End
+	if rec.Size > 0 && end < 0 {
+		end = rec.Size - 1
+	}
+
+	// We don't yet know the resource's total size (new download, or an
+	// open-ended range on one we haven't started) - ask upstream for the
+	// requested window first so its Content-Range response tells us.
+	if rec.Size <= 0 || end < 0 {
+		ct, mu, ferr := fetchRangeInto(targets, cachePath, headers, reqRange.Start, end, rec)
+		if ferr != nil {
+			unlock()
+			return nil, 0, "", "", ferr
+		}
+		contentType, mirror = ct, mu
+		if end < 0 {
+			end = rec.Size - 1
+		}
+	}
+
+	for _, gap := range rec.missing(reqRange.Start, end+1) {
+		ct, mu, ferr := fetchRangeInto(targets, cachePath, headers, gap.Start, gap.End-1, rec)
+		if ferr != nil {
+			unlock()
+			return nil, 0, "", "", ferr
+		}
+		if contentType == "" {
+			contentType = ct
+		}
+		mirror = mu
+	}
+
+	if rec.complete() {
+		promotePartial(cachePath, rec, contentType)
+	} else if err := writeParts(cachePath, rec); err != nil {
+		logger.Get().Warnf("Failed to persist .parts sidecar for %s: %v", cachePath, err)
+	}
+	unlock()
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+	if _, err := f.Seek(reqRange.Start, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, "", "", err
+	}
+
+	return &rangeReadCloser{r: io.LimitReader(f, end-reqRange.Start+1), f: f}, rec.Size, contentType, mirror, nil
+}
+
+// fetchRangeInto issues an upstream Range request for [start, end] (end < 0
+// meaning open-ended) against each mirror in turn, writes the response body
+// into cachePath at the right offset, and records the interval in rec.
+func fetchRangeInto(targets []mirrors.FetchTarget, cachePath string, headers map[string]string, start, end int64, rec *partsRecord) (contentType, mirror string, err error) {
+	log := logger.Get()
+
+	reqHeaders := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		reqHeaders[k] = v
+	}
+	if end >= 0 {
+		reqHeaders["Range"] = fmt.Sprintf("bytes=%d-%d", start, end)
+	} else {
+		reqHeaders["Range"] = fmt.Sprintf("bytes=%d-", start)
+	}
+
+	var lastErr error
+	ordered := mirrors.OrderForFetch(targets)
+
+	for _, target := range ordered {
+		fetchStart := time.Now()
+		resp, ferr := fetchUpstream(target.URL, reqHeaders)
+		if ferr != nil {
+			mirrors.RecordFetchResult(target.Mirror, false, time.Since(fetchStart))
+			lastErr = ferr
+			continue
+		}
+
+		total, gotStart, gotEnd, perr := parseContentRange(resp.Header.Get("Content-Range"), resp.ContentLength, start)
+		if perr != nil {
+			resp.Body.Close()
+			mirrors.RecordFetchResult(target.Mirror, false, time.Since(fetchStart))
+			lastErr = perr
+			continue
+		}
+
+		werr := writeAt(cachePath, gotStart, resp.Body)
+		resp.Body.Close()
+		if werr != nil {
+			mirrors.RecordFetchResult(target.Mirror, false, time.Since(fetchStart))
+			lastErr = werr
+			continue
+		}
+
+		rec.Size = total
+		rec.addRange(gotStart, gotEnd+1)
+		mirrors.RecordFetchResult(target.Mirror, true, time.Since(fetchStart))
+		return resp.Header.Get("Content-Type"), target.URL, nil
+	}
+
+	log.Warnf("Range fetch failed for all %d mirrors: %v", len(ordered), lastErr)
+	return "", "", fmt.Errorf("range fetch failed for all %d mirrors: %v", len(ordered), lastErr)
+}
+
+// parseContentRange extracts (total, start, end) from an upstream
+// "Content-Range: bytes start-end/total" response header. Falls back to
+// treating the body as the whole resource, starting at offset 0 with its
+// Content-Length as the total, when the header is absent (a server that
+// ignores Range and just sends 200 with the whole file).
+func parseContentRange(header string, contentLength, reqStart int64) (total, start, end int64, err error) {
+	if header == "" {
+		if contentLength <= 0 {
+			return 0, 0, 0, fmt.Errorf("missing Content-Range and Content-Length on range response")
+		}
+		return contentLength, 0, contentLength - 1, nil
+	}
+
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %s", header)
+	}
+	return total, start, end, nil
+}
+
+// writeAt writes r into cachePath starting at offset, creating the (sparse)
+// file if it doesn't exist yet, without truncating bytes already written by
+// an earlier range fetch.
+func writeAt(cachePath string, offset int64, r io.Reader) error {
+	f, err := os.OpenFile(cachePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// promotePartial is called once rec's intervals fully cover [0, rec.Size):
+// it drops the .parts sidecar and writes a normal .meta record so the entry
+// behaves like any other complete cache file (IsCacheValid, serveFromCache,
+// etc). Range-assembled entries don't get a SHA256 recorded - doing so would
+// mean re-reading the whole file here - so the background scrubber (which
+// skips entries with no recorded digest, see digestMismatch) won't verify
+// them; that's an accepted gap rather than paying for a second full read.
+func promotePartial(cachePath string, rec *partsRecord, contentType string) {
+	os.Remove(partsPath(cachePath))
+
+	meta := Meta{
+		Size:        rec.Size,
+		ContentType: contentType,
+		CachedAt:    time.Now(),
+	}
+	if data, err := json.Marshal(meta); err == nil {
+		os.WriteFile(cachePath+".meta", data, 0644)
+	}
+}
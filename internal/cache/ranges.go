@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// byteRange is a half-open interval [Start, End) of bytes already present in
+// a partially-downloaded cache file.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// partsRecord is the on-disk shape of a cachePath+".parts" sidecar: which
+// byte ranges of the eventual Size have been fetched so far. Once Ranges
+// covers [0, Size) the sidecar is removed and the cache file is promoted to
+// a normal, complete entry (see promotePartial).
+type partsRecord struct {
+	Size   int64       `json:"size"`
+	Ranges []byteRange `json:"ranges"`
+}
+
+func partsPath(cachePath string) string {
+	return cachePath + ".parts"
+}
+
+func readParts(cachePath string) (*partsRecord, error) {
+	data, err := os.ReadFile(partsPath(cachePath))
+	if err != nil {
+		return nil, err
+	}
+	var rec partsRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func writeParts(cachePath string, rec *partsRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partsPath(cachePath), data, 0644)
+}
+
+// addRange merges [start, end) into rec.Ranges, coalescing overlapping or
+// adjacent intervals so the list stays small as more of the file arrives.
+func (rec *partsRecord) addRange(start, end int64) {
+	rec.Ranges = append(rec.Ranges, byteRange{Start: start, End: end})
+	sort.Slice(rec.Ranges, func(i, j int) bool { return rec.Ranges[i].Start < rec.Ranges[j].Start })
+
+	merged := rec.Ranges[:0]
+	for _, rg := range rec.Ranges {
+		if len(merged) > 0 && rg.Start <= merged[len(merged)-1].End {
+			if rg.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = rg.End
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	rec.Ranges = merged
+}
+
+// complete reports whether rec.Ranges fully covers [0, rec.Size).
+func (rec *partsRecord) complete() bool {
+	return rec.Size > 0 && len(rec.Ranges) == 1 && rec.Ranges[0].Start == 0 && rec.Ranges[0].End >= rec.Size
+}
+
+// missing returns the sub-intervals of [start, end) not yet covered by
+// rec.Ranges, in order.
+func (rec *partsRecord) missing(start, end int64) []byteRange {
+	var gaps []byteRange
+	cursor := start
+	for _, rg := range rec.Ranges {
+		if rg.End <= cursor {
+			continue
+		}
+		if rg.Start >= end {
+			break
+		}
+		if rg.Start > cursor {
+			gaps = append(gaps, byteRange{Start: cursor, End: rg.Start})
+		}
+		if rg.End > cursor {
+			cursor = rg.End
+		}
+	}
+	if cursor < end {
+		gaps = append(gaps, byteRange{Start: cursor, End: end})
+	}
+	return gaps
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddRangeMerges(t *testing.T) {
+	cases := []struct {
+		name   string
+		ranges []byteRange
+		add    byteRange
+		want   []byteRange
+	}{
+		{
+			name:   "first range",
+			ranges: nil,
+			add:    byteRange{Start: 0, End: 100},
+			want:   []byteRange{{Start: 0, End: 100}},
+		},
+		{
+			name:   "disjoint ranges stay separate",
+			ranges: []byteRange{{Start: 0, End: 100}},
+			add:    byteRange{Start: 200, End: 300},
+			want:   []byteRange{{Start: 0, End: 100}, {Start: 200, End: 300}},
+		},
+		{
+			name:   "adjacent ranges coalesce",
+			ranges: []byteRange{{Start: 0, End: 100}},
+			add:    byteRange{Start: 100, End: 200},
+			want:   []byteRange{{Start: 0, End: 200}},
+		},
+		{
+			name:   "overlapping ranges coalesce",
+			ranges: []byteRange{{Start: 0, End: 100}},
+			add:    byteRange{Start: 50, End: 150},
+			want:   []byteRange{{Start: 0, End: 150}},
+		},
+		{
+			name:   "out-of-order insert still merges",
+			ranges: []byteRange{{Start: 200, End: 300}},
+			add:    byteRange{Start: 0, End: 200},
+			want:   []byteRange{{Start: 0, End: 300}},
+		},
+		{
+			name:   "fully contained range is a no-op",
+			ranges: []byteRange{{Start: 0, End: 300}},
+			add:    byteRange{Start: 100, End: 200},
+			want:   []byteRange{{Start: 0, End: 300}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &partsRecord{Ranges: append([]byteRange{}, tc.ranges...)}
+			rec.addRange(tc.add.Start, tc.add.End)
+			if !reflect.DeepEqual(rec.Ranges, tc.want) {
+				t.Errorf("addRange() = %v, want %v", rec.Ranges, tc.want)
+			}
+		})
+	}
+}
+
+func TestPartsRecordComplete(t *testing.T) {
+	cases := []struct {
+		name string
+		rec  partsRecord
+		want bool
+	}{
+		{"empty record", partsRecord{}, false},
+		{"size unknown", partsRecord{Size: -1, Ranges: []byteRange{{Start: 0, End: 100}}}, false},
+		{"partial coverage", partsRecord{Size: 100, Ranges: []byteRange{{Start: 0, End: 50}}}, false},
+		{"split coverage", partsRecord{Size: 100, Ranges: []byteRange{{Start: 0, End: 50}, {Start: 50, End: 100}}}, false},
+		{"full coverage", partsRecord{Size: 100, Ranges: []byteRange{{Start: 0, End: 100}}}, true},
+		{"coverage past size", partsRecord{Size: 100, Ranges: []byteRange{{Start: 0, End: 150}}}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.rec.complete(); got != tc.want {
+				t.Errorf("complete() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPartsRecordMissing(t *testing.T) {
+	cases := []struct {
+		name       string
+		ranges     []byteRange
+		start, end int64
+		want       []byteRange
+	}{
+		{
+			name:   "nothing cached yet",
+			ranges: nil,
+			start:  0, end: 100,
+			want: []byteRange{{Start: 0, End: 100}},
+		},
+		{
+			name:   "fully cached",
+			ranges: []byteRange{{Start: 0, End: 100}},
+			start:  0, end: 100,
+			want: nil,
+		},
+		{
+			name:   "gap in the middle",
+			ranges: []byteRange{{Start: 0, End: 50}, {Start: 80, End: 100}},
+			start:  0, end: 100,
+			want: []byteRange{{Start: 50, End: 80}},
+		},
+		{
+			name:   "gap before and after a cached middle",
+			ranges: []byteRange{{Start: 40, End: 60}},
+			start:  0, end: 100,
+			want: []byteRange{{Start: 0, End: 40}, {Start: 60, End: 100}},
+		},
+		{
+			name:   "requested window narrower than cached range",
+			ranges: []byteRange{{Start: 0, End: 100}},
+			start:  20, end: 40,
+			want: nil,
+		},
+		{
+			name:   "cached range outside requested window is ignored",
+			ranges: []byteRange{{Start: 0, End: 10}, {Start: 200, End: 210}},
+			start:  50, end: 150,
+			want: []byteRange{{Start: 50, End: 150}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &partsRecord{Ranges: tc.ranges}
+			got := rec.missing(tc.start, tc.end)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("missing(%d, %d) = %v, want %v", tc.start, tc.end, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/logger"
+)
+
+// scrubBatchSize bounds how many cache entries ScrubOnce re-hashes per call,
+// so re-verifying a large cache doesn't turn into a continuous full-disk
+// read; the worker package ticks ScrubOnce on an interval instead.
+const scrubBatchSize = 50
+
+var errScrubBudgetReached = errors.New("scrub budget reached for this pass")
+
+// scrubOffset counts the entries earlier ScrubOnce calls of the current walk
+// have already checked, so each call resumes after them.
+var scrubOffset int
+
+// ScrubOnce re-hashes a bounded batch of cache entries against the SHA256
+// recorded in their .meta sidecar (see streamingReader.Close) and
+// quarantines any whose digest doesn't match - bitrot or on-disk corruption
+// that the cheap size check in IsCacheValid wouldn't catch. Each call picks
+// up after the previous batch and starts over once the whole cache has been
+// walked.
+func ScrubOnce() error {
+	cfg := config.Get()
+	log := logger.Get()
+	checked := 0
+	quarantined := 0
+	skipped := 0
+
+	err := filepath.Walk(cfg.StoragePathResolved, func(p string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".quarantine" || info.Name() == "pool" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".meta") || strings.HasSuffix(p, ".tmp") || strings.HasSuffix(p, ".parts") {
+			return nil
+		}
+		// Still being assembled by StreamRange - not corrupted, just incomplete.
+		if _, statErr := os.Stat(partsPath(p)); statErr == nil {
+			return nil
+		}
+		if skipped < scrubOffset {
+			skipped++
+			return nil
+		}
+		if checked >= scrubBatchSize {
+			return errScrubBudgetReached
+		}
+		checked++
+
+		if digestMismatch(p) {
+			quarantine(p)
+			quarantined++
+		}
+		return nil
+	})
+
+	if err == errScrubBudgetReached {
+		scrubOffset += checked - quarantined
+	} else {
+		scrubOffset = 0
+	}
+
+	if checked > 0 {
+		log.Debugf("Cache scrubber: checked %d entries", checked)
+	}
+	if err != nil && err != errScrubBudgetReached {
+		return err
+	}
+	return nil
+}
+
+// digestMismatch reports whether cachePath's contents no longer match the
+// SHA256 recorded in its .meta sidecar. Entries with no recorded digest
+// (cached before bitrot protection existed) are left alone.
+func digestMismatch(cachePath string) bool {
+	data, err := os.ReadFile(cachePath + ".meta")
+	if err != nil {
+		return false
+	}
+	var rec Meta
+	if json.Unmarshal(data, &rec) != nil || rec.SHA256 == "" {
+		return false
+	}
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) != rec.SHA256
+}
+
+// quarantine moves a corrupted cache entry (and its sidecar) aside into
+// StoragePathResolved/.quarantine instead of deleting it outright, so an
+// operator can inspect what went wrong. If cachePath is a known SHA256's
+// hardlink into the content-addressed pool (see pool.go), the pool entry
+// itself is removed first: renaming cachePath alone only removes one
+// directory entry pointing at the same corrupted inode, so every other cache
+// path still hardlinked to it (and any future TryDedupeFromPool hit) would
+// keep serving the same corrupted bytes otherwise. Each of those other
+// hardlinked paths gets its own turn through ScrubOnce and is quarantined in
+// the same way as it's reached.
+func quarantine(cachePath string) {
+	log := logger.Get()
+	cfg := config.Get()
+
+	if sha256Hex, ok := metaSHA256(cachePath); ok {
+		pool := poolPath(sha256Hex)
+		if err := os.Remove(pool); err == nil {
+			log.Warnf("Cache scrubber: removed corrupted pool entry %s (sha256=%s)", pool, sha256Hex)
+		} else if !os.IsNotExist(err) {
+			log.Errorf("Cache scrubber: failed to remove corrupted pool entry %s: %v", pool, err)
+		}
+	}
+
+	rel, err := filepath.Rel(cfg.StoragePathResolved, cachePath)
+	if err != nil {
+		rel = filepath.Base(cachePath)
+	}
+
+	dest := filepath.Join(cfg.StoragePathResolved, ".quarantine", rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		log.Errorf("Cache scrubber: failed to quarantine %s: %v", cachePath, err)
+		return
+	}
+
+	if err := os.Rename(cachePath, dest); err != nil {
+		log.Errorf("Cache scrubber: failed to quarantine %s: %v", cachePath, err)
+		return
+	}
+	os.Rename(cachePath+".meta", dest+".meta")
+
+	log.Warnf("Cache scrubber: quarantined corrupted cache entry %s -> %s", cachePath, dest)
+}
+
+// metaSHA256 reads the SHA256 recorded in cachePath's .meta sidecar, if any.
+func metaSHA256(cachePath string) (string, bool) {
+	data, err := os.ReadFile(cachePath + ".meta")
+	if err != nil {
+		return "", false
+	}
+	var rec Meta
+	if json.Unmarshal(data, &rec) != nil || rec.SHA256 == "" {
+		return "", false
+	}
+	return rec.SHA256, true
+}
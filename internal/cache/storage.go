@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/logger"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Meta is the subset of file metadata every Storage backend can report,
+// independent of whether cache files live on local disk or in an object
+// store. SHA256/ContentType/ETag/LastModified/CachedAt are populated from
+// the JSON sidecar written by streamingReader.Close when present; older
+// entries without a sidecar just carry Size/ModTime.
+type Meta struct {
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"-"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CachedAt     time.Time `json:"cached_at,omitempty"`
+}
+
+// Storage abstracts cache file I/O behind keys (paths relative to the
+// storage root) so callers like proxy.Handler.serveFromCache and
+// stats.UpdateFileStats don't need to know whether the active backend is
+// local disk or a shared S3-compatible bucket. This is what lets multiple
+// proxy replicas share one cache.
+type Storage interface {
+	Stat(key string) (Meta, error)
+	Get(key string) (io.ReadSeekCloser, Meta, error)
+	PutStreaming(key string) (io.WriteCloser, error)
+	Walk(prefix string, fn func(key string, meta Meta) error) error
+	Touch(key string, atime time.Time) error
+	Remove(key string) error
+}
+
+var (
+	storageOnce   sync.Once
+	activeStorage Storage
+)
+
+// ActiveStorage returns the process-wide Storage backend. It's initialized
+// lazily on first use and reused for the life of the process, and it is
+// always the local-disk backend, whatever cfg.StorageBackend says.
+//
+// The s3Storage backend itself (Stat/Get/PutStreaming/Walk/Touch/Remove) is
+// fully implemented, but nothing outside serveFromCache and stats.go reads
+// through the Storage interface yet: downloadAndCache/streamingReader write
+// straight to local disk via os.Create/os.Rename, IsCacheValid os.Stats the
+// local path directly, and the content-addressed pool (pool.go) and range
+// assembly (range_fetch.go) both depend on hardlinking/sparse-writing a
+// local file, neither of which has a sane equivalent against an S3 object.
+// Selecting "s3" today would mean every download still lands on local disk
+// while every read goes to a bucket that was never written to - a
+// guaranteed failure, not a degraded mode. So s3 is refused here: an error
+// is logged and local disk is used instead.
+func ActiveStorage() Storage {
+	storageOnce.Do(func() {
+		cfg := config.Get()
+		if cfg.StorageBackend == "s3" {
+			logger.Get().Errorf("storage_backend=s3 is configured, but the cache write path (downloads, pool dedupe, range assembly, scrubber quarantine) only supports local disk - falling back to local disk. See ActiveStorage's doc comment.")
+		}
+		activeStorage = newLocalStorage(cfg.StoragePathResolved)
+	})
+	return activeStorage
+}
+
+// RelKey converts an absolute cache path (as returned by GetCachePath) into
+// the storage key used by Storage, i.e. the path relative to
+// cfg.StoragePathResolved.
+func RelKey(cachePath string) (string, error) {
+	cfg := config.Get()
+	return filepath.Rel(cfg.StoragePathResolved, cachePath)
+}
+
+// localStorage is the original on-disk backend, unchanged in behavior from
+// before the Storage interface existed.
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) Storage {
+	return &localStorage{root: root}
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.root, key)
+}
+
+func (s *localStorage) Stat(key string) (Meta, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return Meta{}, err
+	}
+	return s.withSidecar(key, Meta{Size: info.Size(), ModTime: info.ModTime()}), nil
+}
+
+func (s *localStorage) Get(key string) (io.ReadSeekCloser, Meta, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Meta{}, err
+	}
+	return f, s.withSidecar(key, Meta{Size: info.Size(), ModTime: info.ModTime()}), nil
+}
+
+// withSidecar overlays the JSON record written alongside key (see
+// streamingReader.Close in cache.go) onto base, falling back to base untouched when
+// there's no sidecar (e.g. entries cached before bitrot protection existed).
+func (s *localStorage) withSidecar(key string, base Meta) Meta {
+	data, err := os.ReadFile(s.path(key) + ".meta")
+	if err != nil {
+		return base
+	}
+	var sidecar Meta
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return base
+	}
+	sidecar.ModTime = base.ModTime
+	if sidecar.Size == 0 {
+		sidecar.Size = base.Size
+	}
+	return sidecar
+}
+
+func (s *localStorage) PutStreaming(key string) (io.WriteCloser, error) {
+	full := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(full)
+}
+
+func (s *localStorage) Walk(prefix string, fn func(key string, meta Meta) error) error {
+	root := s.path(prefix)
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			// pool/ holds the content-addressed blobs that per-distro cache
+			// entries are hardlinked from (see pool.go); walking it too would
+			// double-count the same on-disk bytes under a second key.
+			if info.Name() == "pool" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".meta") || strings.HasSuffix(p, ".tmp") || strings.HasSuffix(p, ".parts") {
+			return nil
+		}
+		key, relErr := filepath.Rel(s.root, p)
+		if relErr != nil {
+			return nil
+		}
+		return fn(key, s.withSidecar(key, Meta{Size: info.Size(), ModTime: info.ModTime()}))
+	})
+}
+
+func (s *localStorage) Touch(key string, atime time.Time) error {
+	full := s.path(key)
+	info, err := os.Stat(full)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(full, atime, info.ModTime())
+}
+
+func (s *localStorage) Remove(key string) error {
+	return os.Remove(s.path(key))
+}
+
+// s3Storage stores cache entries as objects in an S3-compatible bucket
+// (AWS S3, MinIO, etc.), so a fleet of proxy replicas can share one cache
+// instead of each keeping a cold, independent local copy.
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Storage(cfg *config.Config) (Storage, error) {
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure: cfg.S3UseSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{client: client, bucket: cfg.S3Bucket}, nil
+}
+
+func (s *s3Storage) Stat(key string) (Meta, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Meta{}, err
+	}
+	return Meta{Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *s3Storage) Get(key string) (io.ReadSeekCloser, Meta, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Meta{}, err
+	}
+	return obj, Meta{Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *s3Storage) PutStreaming(key string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, key, pr, -1, minio.PutObjectOptions{})
+		pr.CloseWithError(err)
+	}()
+	return pw, nil
+}
+
+func (s *s3Storage) Walk(prefix string, fn func(key string, meta Meta) error) error {
+	ctx := context.Background()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			continue
+		}
+		if err := fn(obj.Key, Meta{Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Touch is a no-op on S3: object stores don't track access time. Cache
+// retention (CleanOldCache) walks local disk only and never reaches this
+// backend.
+func (s *s3Storage) Touch(key string, atime time.Time) error {
+	return nil
+}
+
+func (s *s3Storage) Remove(key string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,156 @@
+// Package chaos injects configurable faults into upstream fetches and
+// client responses, so apt-cache-proxy's retry, circuit-breaking, and
+// streaming code paths can be exercised against an unstable network without
+// needing an actual flaky mirror. Every function here is a no-op unless
+// cfg.ChaosEnabled is true.
+package chaos
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"apt-cache-proxy/internal/config"
+)
+
+// Enabled reports whether fault injection is turned on.
+func Enabled() bool {
+	return config.Get().ChaosEnabled
+}
+
+// faultFor returns the configured fault profile for a mirror URL, falling
+// back to the "*" wildcard entry if the mirror has none of its own.
+func faultFor(mirror string) (config.ChaosFault, bool) {
+	cfg := config.Get()
+	if f, ok := cfg.ChaosMirrorFaults[mirror]; ok {
+		return f, true
+	}
+	if f, ok := cfg.ChaosMirrorFaults["*"]; ok {
+		return f, true
+	}
+	return config.ChaosFault{}, false
+}
+
+// BeforeFetch sleeps for the mirror's configured latency (if any) and then
+// decides whether this fetch attempt should be treated as a refused
+// connection or rewritten into a forced 5xx, before downloadAndCache dials
+// out. Both return values are zero when chaos is disabled or the mirror has
+// no fault profile.
+func BeforeFetch(mirror string) (refused bool, forcedStatus int) {
+	if !Enabled() {
+		return false, 0
+	}
+	f, ok := faultFor(mirror)
+	if !ok {
+		return false, 0
+	}
+
+	injectLatency(f)
+
+	if roll(f.ConnRefusePct) {
+		return true, 0
+	}
+	if roll(f.ServerErrorPct) {
+		return false, http.StatusServiceUnavailable
+	}
+	return false, 0
+}
+
+// ShouldTruncate reports whether a download from mirror should be cut off
+// mid-stream, simulating a mirror or network that drops the connection
+// partway through a transfer.
+func ShouldTruncate(mirror string) bool {
+	if !Enabled() {
+		return false
+	}
+	f, ok := faultFor(mirror)
+	return ok && roll(f.TruncatePct)
+}
+
+func injectLatency(f config.ChaosFault) {
+	if f.LatencyMaxMS <= 0 {
+		return
+	}
+	min, max := f.LatencyMinMS, f.LatencyMaxMS
+	if max < min {
+		max = min
+	}
+	delay := min
+	if max > min {
+		delay += rand.Intn(max - min + 1)
+	}
+	time.Sleep(time.Duration(delay) * time.Millisecond)
+}
+
+func roll(pct float64) bool {
+	return pct > 0 && rand.Float64()*100 < pct
+}
+
+// ClientBandwidthKbps returns the configured client-side throttle, or 0 if
+// chaos is disabled or no throttle is set.
+func ClientBandwidthKbps() int {
+	if !Enabled() {
+		return 0
+	}
+	return config.Get().ChaosClientBandwidthKbps
+}
+
+// CopyToClient streams src to dst, throttled to approximately kbps
+// kilobits/sec when kbps > 0, so integration tests can reproduce a slow apt
+// client. With kbps <= 0 it behaves exactly
+// like io.Copy.
+func CopyToClient(dst io.Writer, src io.Reader, kbps int) (int64, error) {
+	if kbps <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	const chunkSize = 32 * 1024
+	bytesPerSec := float64(kbps) * 1024 / 8
+
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			time.Sleep(time.Duration(float64(n) / bytesPerSec * float64(time.Second)))
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return written, nil
+			}
+			return written, readErr
+		}
+	}
+}
+
+// TruncatingReader wraps r so that reads stop after limit bytes and return
+// io.ErrUnexpectedEOF - simulating a mirror that drops the connection
+// mid-transfer.
+type TruncatingReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// NewTruncatingReader caps r at limit bytes. With a non-positive limit the
+// very first Read already returns the truncation error.
+func NewTruncatingReader(r io.Reader, limit int64) *TruncatingReader {
+	return &TruncatingReader{r: r, remaining: limit}
+}
+
+func (t *TruncatingReader) Read(p []byte) (int, error) {
+	if t.remaining <= 0 {
+		return 0, fmt.Errorf("chaos: truncated mid-stream: %w", io.ErrUnexpectedEOF)
+	}
+	if int64(len(p)) > t.remaining {
+		p = p[:t.remaining]
+	}
+	n, err := t.r.Read(p)
+	t.remaining -= int64(n)
+	return n, err
+}
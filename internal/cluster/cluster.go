@@ -0,0 +1,346 @@
+// Package cluster provides optional Raft-based consensus so multiple
+// apt-cache-proxy instances can share a single authoritative copy of the
+// mirror list, blacklist, and config that would otherwise live only in the
+// local SQLite database. It is a no-op wrapper around local state when
+// clustering is disabled, so single-node deployments are unaffected.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"apt-cache-proxy/internal/logger"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// Config holds the cluster settings resolved from config.json by the caller.
+// Kept separate from internal/config to avoid an import cycle, since
+// mirrors/cache/config register Raft command handlers here.
+type Config struct {
+	Enabled   bool
+	Bind      string
+	Bootstrap bool
+	Peers     []string
+	BaseDir   string
+
+	// HTTPAddr is this node's admin API address (cfg.Host:cfg.Port), as
+	// opposed to Bind (the Raft RPC transport address). Registered in
+	// peerHTTPAddrs on startup so LeaderHTTPAddr can tell other nodes where
+	// to redirect admin writes.
+	HTTPAddr string
+}
+
+// Command is a single mutation applied through the Raft log.
+type Command struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type handlerFunc func(payload []byte) error
+
+// snapshotFunc returns a package's full Raft-replicated state, serialized so
+// restoreFunc on any node (including one with no history at all, e.g. a
+// fresh joiner) can reconstruct it. restoreFunc must replace the package's
+// state wholesale, not merge into it.
+type snapshotFunc func() ([]byte, error)
+type restoreFunc func([]byte) error
+
+var (
+	node     *raft.Raft
+	fsm      *stateMachine
+	handlers = map[string]handlerFunc{}
+	mu       sync.RWMutex
+	enabled  bool
+
+	// snapshotters/restorers back the FSM's Snapshot/Restore (see fsm.go),
+	// keyed the same way as handlers so stateMachine.Snapshot can capture -
+	// and a joining or restoring node's stateMachine.Restore can replay -
+	// every registered package's state without cluster importing any of
+	// them.
+	snapshotters = map[string]snapshotFunc{}
+	restorers    = map[string]restoreFunc{}
+
+	// peerHTTPAddrs maps a Raft ServerID (the cluster.bind address used as
+	// raftConfig.LocalID) to that node's admin HTTP address. Replicated via
+	// Apply/the FSM like any other cluster command, so every node -
+	// including one that takes over as leader later - has it.
+	peerHTTPAddrs = map[string]string{}
+)
+
+func init() {
+	Register("cluster.peer_addr", applyPeerAddrCmd)
+}
+
+type peerAddrCmd struct {
+	ID       string `json:"id"`
+	HTTPAddr string `json:"http_addr"`
+}
+
+func applyPeerAddrCmd(payload []byte) error {
+	var cmd peerAddrCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	peerHTTPAddrs[cmd.ID] = cmd.HTTPAddr
+	mu.Unlock()
+	return nil
+}
+
+// ErrNotLeader is returned by Apply when this node cannot accept writes and
+// the caller should redirect the request to the current leader.
+var ErrNotLeader = errors.New("cluster: not the leader")
+
+// Register associates a command type with the function that applies it to
+// local state. Packages call this from their own init() so this package
+// never needs to import mirrors/cache/config.
+func Register(cmdType string, fn handlerFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[cmdType] = fn
+}
+
+// RegisterSnapshot associates name with the functions that capture and
+// restore one package's full Raft-replicated state, so periodic Raft
+// snapshots (see snapshotLoop) actually carry that state forward instead of
+// just letting the log they're truncating be the only copy. Packages that
+// call Register for their command handlers should also call this from the
+// same init(), under a name distinct from any other package's (e.g.
+// "mirrors", "blacklist", "config").
+func RegisterSnapshot(name string, snap snapshotFunc, restore restoreFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshotters[name] = snap
+	restorers[name] = restore
+}
+
+// Start initializes the Raft node when cfg.Enabled is set. Safe to call with
+// Enabled=false, in which case Apply falls back to applying commands locally.
+func Start(cfg Config) error {
+	log := logger.Get()
+
+	if !cfg.Enabled {
+		log.Info("Clustering disabled, running single-node")
+		return nil
+	}
+
+	raftDir := filepath.Join(cfg.BaseDir, "raft")
+	if err := os.MkdirAll(raftDir, 0755); err != nil {
+		return err
+	}
+
+	fsm = &stateMachine{}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(cfg.Bind)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Bind)
+	if err != nil {
+		return fmt.Errorf("resolving cluster.bind %q: %w", cfg.Bind, err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.Bind, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(raftDir, 2, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "raft-log.db"))
+	if err != nil {
+		return err
+	}
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "raft-stable.db"))
+	if err != nil {
+		return err
+	}
+
+	node, err = raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshots, transport)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Bootstrap {
+		servers := []raft.Server{{ID: raftConfig.LocalID, Address: transport.LocalAddr()}}
+		for _, peer := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(peer), Address: raft.ServerAddress(peer)})
+		}
+		node.BootstrapCluster(raft.Configuration{Servers: servers})
+	}
+
+	mu.Lock()
+	enabled = true
+	mu.Unlock()
+
+	if cfg.Bootstrap {
+		// The bootstrapping node is its own leader from the start, so it can
+		// register its own HTTP address right away; a node that joins an
+		// existing cluster instead gets registered by the leader it joins
+		// through (see Join/clusterJoinHandler).
+		if err := RegisterPeerAddr(cfg.Bind, cfg.HTTPAddr); err != nil {
+			log.Warnf("Failed to register this node's admin address: %v", err)
+		}
+	}
+
+	go snapshotLoop()
+
+	log.Infof("Cluster node started on %s", cfg.Bind)
+	return nil
+}
+
+// RegisterPeerAddr records addr as the admin HTTP address for the node
+// identified by the Raft ServerID id, replicated to every node via Apply so
+// LeaderHTTPAddr keeps working after a leadership change. Must be called on
+// the current leader (same restriction as Join).
+func RegisterPeerAddr(id, addr string) error {
+	return Apply("cluster.peer_addr", peerAddrCmd{ID: id, HTTPAddr: addr})
+}
+
+// Enabled reports whether this node is running as part of a cluster.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled
+}
+
+// IsLeader reports whether this node may currently accept writes. In
+// single-node mode (clustering disabled) every node is its own leader.
+func IsLeader() bool {
+	if node == nil {
+		return true
+	}
+	return node.State() == raft.Leader
+}
+
+// LeaderAddr returns the Raft transport address of the current leader (the
+// cluster.bind host:port used for the binary Raft RPC protocol), if known.
+// Not reachable as an HTTP admin API - see LeaderHTTPAddr for that.
+func LeaderAddr() string {
+	if node == nil {
+		return ""
+	}
+	addr, _ := node.LeaderWithID()
+	return string(addr)
+}
+
+// LeaderHTTPAddr returns the admin HTTP address of the current leader, as
+// registered via RegisterPeerAddr when it joined the cluster, so callers
+// like leaderRedirectMiddleware can redirect a write there. Returns "" if
+// there's no known leader or its HTTP address hasn't been registered yet.
+func LeaderHTTPAddr() string {
+	if node == nil {
+		return ""
+	}
+	_, id := node.LeaderWithID()
+	if id == "" {
+		return ""
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return peerHTTPAddrs[string(id)]
+}
+
+// Apply runs a registered command through the Raft log when clustering is
+// enabled, or directly against local state otherwise. Followers return
+// ErrNotLeader so callers can redirect the client to the leader.
+func Apply(cmdType string, payload interface{}) error {
+	mu.RLock()
+	fn, ok := handlers[cmdType]
+	clusterEnabled := enabled
+	mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("cluster: no handler registered for %q", cmdType)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if !clusterEnabled {
+		return fn(data)
+	}
+
+	if !IsLeader() {
+		return ErrNotLeader
+	}
+
+	cmdData, err := json.Marshal(Command{Type: cmdType, Payload: data})
+	if err != nil {
+		return err
+	}
+
+	future := node.Apply(cmdData, 10*time.Second)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if fsmErr, ok := future.Response().(error); ok && fsmErr != nil {
+		return fsmErr
+	}
+	return nil
+}
+
+// Peers returns the current Raft configuration's server list.
+func Peers() ([]raft.Server, error) {
+	if node == nil {
+		return nil, errors.New("cluster: not running")
+	}
+	future := node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	return future.Configuration().Servers, nil
+}
+
+// Join adds a voter to the cluster and records its admin HTTP address
+// (httpAddr, distinct from addr - the Raft transport address) so
+// LeaderHTTPAddr can find it once it's elected leader. Must be called on the
+// leader.
+func Join(id, addr, httpAddr string) error {
+	if node == nil || !IsLeader() {
+		return ErrNotLeader
+	}
+	if err := node.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error(); err != nil {
+		return err
+	}
+	return RegisterPeerAddr(id, httpAddr)
+}
+
+// Leave removes a voter from the cluster. Must be called on the leader.
+func Leave(id string) error {
+	if node == nil || !IsLeader() {
+		return ErrNotLeader
+	}
+	return node.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+// snapshotLoop periodically asks Raft to snapshot and compact its log so
+// disk usage stays bounded; each snapshot carries every registered package's
+// state (see stateMachine.Snapshot), which stateMachine.Restore replays.
+func snapshotLoop() {
+	log := logger.Get()
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if node == nil || !IsLeader() {
+			continue
+		}
+		if err := node.Snapshot().Error(); err != nil {
+			log.Warnf("Cluster snapshot failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/hashicorp/raft"
+)
+
+// stateMachine dispatches applied log entries to the handler registered for
+// their command type.
+type stateMachine struct{}
+
+func (f *stateMachine) Apply(entry *raft.Log) interface{} {
+	var cmd Command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return err
+	}
+
+	mu.RLock()
+	fn, ok := handlers[cmd.Type]
+	mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	return fn(cmd.Payload)
+}
+
+// Snapshot captures every registered package's state (see RegisterSnapshot)
+// into a single blob keyed by package name, so node.Snapshot() - which
+// truncates the Raft log up to this point (see snapshotLoop) - doesn't throw
+// away the only copy of that state. Without this, a node that joins after a
+// truncation (or restores from a local snapshot on restart) would see
+// nothing but whatever log entries happened to occur afterward.
+func (f *stateMachine) Snapshot() (raft.FSMSnapshot, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	state := make(map[string]json.RawMessage, len(snapshotters))
+	for name, snap := range snapshotters {
+		data, err := snap()
+		if err != nil {
+			return nil, err
+		}
+		state[name] = data
+	}
+	return &snapshot{state: state}, nil
+}
+
+// Restore replays a snapshot captured by Snapshot, dispatching each named
+// blob to the restorer registered for it. Unknown names (e.g. captured by a
+// newer binary with a package this one doesn't have) are skipped rather than
+// failing the whole restore.
+func (f *stateMachine) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for name, restore := range restorers {
+		payload, ok := state[name]
+		if !ok {
+			continue
+		}
+		if err := restore(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type snapshot struct {
+	state map[string]json.RawMessage
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	if _, err := sink.Write(data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) Release() {}
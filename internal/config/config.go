@@ -1,12 +1,27 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"sync"
+
+	"apt-cache-proxy/internal/cluster"
 )
 
+// ChaosFault models fault-injection probabilities for a single mirror URL
+// (see internal/chaos). Percentages are 0-100; a "*" key in
+// Config.ChaosMirrorFaults applies to any mirror without its own entry.
+type ChaosFault struct {
+	ConnRefusePct  float64 `json:"conn_refuse_pct"`
+	ServerErrorPct float64 `json:"server_error_pct"`
+	TruncatePct    float64 `json:"truncate_pct"`
+	LatencyMinMS   int     `json:"latency_min_ms"`
+	LatencyMaxMS   int     `json:"latency_max_ms"`
+}
+
 type Config struct {
 	Host                   string `json:"host"`
 	Port                   int    `json:"port"`
@@ -14,10 +29,75 @@ type Config struct {
 	DatabasePath           string `json:"database_path"`
 	CacheDays              int    `json:"cache_days"`
 	CacheRetentionEnabled  bool   `json:"cache_retention_enabled"`
+
+	// CacheAfter is the minimum number of requests a URL must see before
+	// it's written to disk; below the threshold it's streamed straight
+	// through (see internal/cache.StreamPassthrough). Defaults to 1, i.e. cache
+	// on first request, matching behavior before this existed.
+	CacheAfter int `json:"cache_after"`
 	LogLevel               string `json:"log_level"`
 	PassthroughMode        bool   `json:"passthrough_mode"`
 	AdminToken             string `json:"admin_token"`
-	
+
+	// JWT secret used to sign admin sessions (see internal/auth). Generated
+	// on first boot if empty and persisted to config.json.
+	JWTSecret string `json:"jwt_secret"`
+
+	// Raft clustering (see internal/cluster)
+	ClusterEnabled   bool     `json:"cluster_enabled"`
+	ClusterBind      string   `json:"cluster_bind"`
+	ClusterBootstrap bool     `json:"cluster_bootstrap"`
+	ClusterPeers     []string `json:"cluster_peers"`
+
+	// Mirror health checking (see internal/mirrors)
+	MirrorProbeIntervalMinutes int    `json:"mirror_probe_interval_minutes"`
+	MirrorFailureThreshold     int    `json:"mirror_failure_threshold"`
+	MirrorProbePath            string `json:"mirror_probe_path"`
+	PreferredCountry           string `json:"preferred_country"`
+
+	// Prometheus metrics (see internal/metrics)
+	MetricsEnabled bool   `json:"metrics_enabled"`
+	MetricsBind    string `json:"metrics_bind"`
+
+	// Structured logging (see internal/logger). LogFormat is "text" (the
+	// default) or "json". When LogFile is set, output is written there
+	// through a rotating lumberjack writer instead of stdout.
+	LogFormat     string `json:"log_format"`
+	LogFile       string `json:"log_file"`
+	LogMaxSizeMB  int    `json:"log_max_size_mb"`
+	LogMaxBackups int    `json:"log_max_backups"`
+	LogMaxAgeDays int    `json:"log_max_age_days"`
+
+	// Cache storage backend (see internal/cache.Storage): "local" (default)
+	// or "s3" for a shared, S3-compatible bucket. "s3" is not honoured:
+	// cache.ActiveStorage logs an error and uses local disk.
+	StorageBackend string `json:"storage_backend"`
+	S3Endpoint     string `json:"s3_endpoint"`
+	S3Bucket       string `json:"s3_bucket"`
+	S3AccessKey    string `json:"s3_access_key"`
+	S3SecretKey    string `json:"s3_secret_key"`
+	S3UseSSL       bool   `json:"s3_use_ssl"`
+
+	// Fault injection for testing unstable upstreams and slow clients (see
+	// internal/chaos). A no-op unless ChaosEnabled is true.
+	ChaosEnabled             bool                  `json:"chaos_enabled"`
+	ChaosMirrorFaults        map[string]ChaosFault `json:"chaos_mirror_faults"`
+	ChaosClientBandwidthKbps int                   `json:"chaos_client_bandwidth_kbps"`
+
+	// Background prefetch of newly-indexed packages (see
+	// internal/cache/prefetch.go and internal/worker's cachePrefetcher).
+	// PrefetchArchitectures restricts which architectures get prefetched per
+	// distro (e.g. {"debian": ["amd64", "arm64"]}); a distro with no entry
+	// prefetches nothing. PrefetchWindowStartHour/EndHour (0-23, local time)
+	// bound prefetching to off-peak hours; equal values mean "always on",
+	// except that both left at 0 (unset) default to a 2-6 window.
+	PrefetchEnabled         bool                `json:"prefetch_enabled"`
+	PrefetchPaused          bool                `json:"prefetch_paused"`
+	PrefetchArchitectures   map[string][]string `json:"prefetch_architectures"`
+	PrefetchWindowStartHour int                 `json:"prefetch_window_start_hour"`
+	PrefetchWindowEndHour   int                 `json:"prefetch_window_end_hour"`
+	PrefetchBatchSize       int                 `json:"prefetch_batch_size"`
+
 	// Resolved paths (computed at runtime)
 	StoragePathResolved  string `json:"-"`
 	DatabasePathResolved string `json:"-"`
@@ -30,6 +110,51 @@ var (
 	once sync.Once
 )
 
+func init() {
+	cluster.Register("config.set", applySetCmd)
+	cluster.RegisterSnapshot("config", snapshotConfig, restoreConfig)
+}
+
+// snapshotConfig serializes the full in-memory Config for cluster.Snapshot,
+// so a node that joins after a Raft log truncation (or restores on restart)
+// ends up with every config.set change applied so far instead of just
+// whatever landed in the log afterward.
+func snapshotConfig() ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return json.Marshal(cfg)
+}
+
+// restoreConfig replaces cfg wholesale from a snapshot captured by
+// snapshotConfig, preserving this node's own resolved paths/BaseDir (which
+// are local to this process, not part of the replicated config.set state),
+// and persists the result to config.json so it survives a plain process
+// restart the same way reload() expects.
+func restoreConfig(data []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	resolvedStoragePath := cfg.StoragePathResolved
+	resolvedDatabasePath := cfg.DatabasePathResolved
+	baseDir := cfg.BaseDir
+
+	var snap Config
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	snap.StoragePathResolved = resolvedStoragePath
+	snap.DatabasePathResolved = resolvedDatabasePath
+	snap.BaseDir = baseDir
+	cfg = &snap
+
+	return saveConfig()
+}
+
+type setCmd struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
 // Load loads configuration from config.json
 func Load() error {
 	var err error
@@ -80,9 +205,34 @@ func reload() error {
 	if newCfg.CacheDays == 0 {
 		newCfg.CacheDays = 7
 	}
+	if newCfg.CacheAfter == 0 {
+		newCfg.CacheAfter = 1
+	}
 	if newCfg.LogLevel == "" {
 		newCfg.LogLevel = "INFO"
 	}
+	if newCfg.MirrorProbeIntervalMinutes == 0 {
+		newCfg.MirrorProbeIntervalMinutes = 5
+	}
+	if newCfg.MirrorFailureThreshold == 0 {
+		newCfg.MirrorFailureThreshold = 3
+	}
+	if newCfg.MirrorProbePath == "" {
+		newCfg.MirrorProbePath = "dists/stable/Release"
+	}
+	if newCfg.StorageBackend == "" {
+		newCfg.StorageBackend = "local"
+	}
+	if newCfg.LogFormat == "" {
+		newCfg.LogFormat = "text"
+	}
+	if newCfg.PrefetchWindowStartHour == 0 && newCfg.PrefetchWindowEndHour == 0 {
+		newCfg.PrefetchWindowStartHour = 2
+		newCfg.PrefetchWindowEndHour = 6
+	}
+	if newCfg.PrefetchBatchSize == 0 {
+		newCfg.PrefetchBatchSize = 20
+	}
 
 	// Resolve paths
 	newCfg.BaseDir = baseDir
@@ -109,10 +259,35 @@ func reload() error {
 		return err
 	}
 
+	needsSave := false
+	if newCfg.JWTSecret == "" {
+		secret, err := generateSecret(32)
+		if err != nil {
+			return err
+		}
+		newCfg.JWTSecret = secret
+		needsSave = true
+	}
+
 	cfg = &newCfg
+
+	if needsSave {
+		if err := saveConfig(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func generateSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // Get returns the current configuration
 func Get() *Config {
 	mu.RLock()
@@ -120,20 +295,51 @@ func Get() *Config {
 	return cfg
 }
 
-// Set updates a configuration value
+// Set updates a configuration value. On a cluster, this is routed through
+// Raft so every node applies the same change; on a single node it takes
+// effect immediately.
 func Set(key string, value interface{}) error {
+	return cluster.Apply("config.set", setCmd{Key: key, Value: value})
+}
+
+func applySetCmd(payload []byte) error {
+	var cmd setCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
 	mu.Lock()
 	defer mu.Unlock()
 
-	switch key {
+	switch cmd.Key {
 	case "cache_days":
-		if v, ok := value.(int); ok {
-			cfg.CacheDays = v
+		if v, ok := cmd.Value.(float64); ok {
+			cfg.CacheDays = int(v)
 		}
 	case "cache_retention_enabled":
-		if v, ok := value.(bool); ok {
+		if v, ok := cmd.Value.(bool); ok {
 			cfg.CacheRetentionEnabled = v
 		}
+	case "cache_after":
+		if v, ok := cmd.Value.(float64); ok {
+			cfg.CacheAfter = int(v)
+		}
+	case "chaos_enabled":
+		if v, ok := cmd.Value.(bool); ok {
+			cfg.ChaosEnabled = v
+		}
+	case "chaos_client_bandwidth_kbps":
+		if v, ok := cmd.Value.(float64); ok {
+			cfg.ChaosClientBandwidthKbps = int(v)
+		}
+	case "prefetch_enabled":
+		if v, ok := cmd.Value.(bool); ok {
+			cfg.PrefetchEnabled = v
+		}
+	case "prefetch_paused":
+		if v, ok := cmd.Value.(bool); ok {
+			cfg.PrefetchPaused = v
+		}
 	}
 
 	// Save to disk
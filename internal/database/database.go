@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"sync"
 
 	"apt-cache-proxy/internal/config"
@@ -66,13 +67,93 @@ func createTables() error {
 	CREATE TABLE IF NOT EXISTS mirrors (
 		name TEXT PRIMARY KEY,
 		urls TEXT,
-		status TEXT DEFAULT 'approved'
+		status TEXT DEFAULT 'approved',
+		type TEXT DEFAULT 'apt'
 	);
 
 	CREATE TABLE IF NOT EXISTS package_blacklist (
 		pattern TEXT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS deb_packages (
+		distro       TEXT,
+		suite        TEXT,
+		component    TEXT,
+		architecture TEXT,
+		package      TEXT,
+		version      TEXT,
+		filename     TEXT,
+		sha256       TEXT,
+		size         INTEGER,
+		depends      TEXT,
+		indexed_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (distro, suite, component, architecture, package, version)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_deb_packages_name ON deb_packages(package);
+	CREATE INDEX IF NOT EXISTS idx_deb_packages_filename ON deb_packages(filename);
+
+	CREATE TABLE IF NOT EXISTS mirror_health (
+		url                  TEXT PRIMARY KEY,
+		latency_ms           INTEGER,
+		last_ok              TIMESTAMP,
+		consecutive_failures INTEGER DEFAULT 0,
+		bytes_per_sec        REAL,
+		country              TEXT,
+		asn                  TEXT,
+		updated_at           TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		username      TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role          TEXT NOT NULL DEFAULT 'viewer',
+		created_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS oci_manifests (
+		registry    TEXT,
+		name        TEXT,
+		reference   TEXT,
+		digest      TEXT,
+		content_type TEXT,
+		cached_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (registry, name, reference)
+	);
+
+	CREATE TABLE IF NOT EXISTS access_counters (
+		pkg_path   TEXT,
+		distro     TEXT,
+		hits       INTEGER DEFAULT 0,
+		first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (pkg_path, distro)
+	);
+
+	CREATE TABLE IF NOT EXISTS deb_releases (
+		distro     TEXT,
+		suite      TEXT,
+		codename   TEXT,
+		components TEXT,
+		architectures TEXT,
+		fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (distro, suite)
+	);
+
+	CREATE TABLE IF NOT EXISTS prefetch_queue (
+		distro       TEXT,
+		suite        TEXT,
+		filename     TEXT,
+		architecture TEXT,
+		sha256       TEXT,
+		size         INTEGER,
+		status       TEXT DEFAULT 'pending',
+		queued_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		fetched_at   TIMESTAMP,
+		PRIMARY KEY (distro, suite, filename)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_prefetch_queue_status ON prefetch_queue(status);
 	`
 
 	_, err := db.Exec(schema)
@@ -193,12 +274,33 @@ func seedDefaultMirrors() error {
 		}
 		urlsJSON += `"]`
 
-		_, err := db.Exec("INSERT INTO mirrors (name, urls, status) VALUES (?, ?, ?)",
+		_, err := db.Exec("INSERT INTO mirrors (name, urls, status, type) VALUES (?, ?, ?, 'apt')",
 			name, urlsJSON, "approved")
 		if err != nil {
 			return err
 		}
 	}
 
+	// OCI/Docker registry mirrors, pulled through by internal/ociproxy
+	defaultRegistries := map[string][]string{
+		"docker.io":       {"https://registry-1.docker.io"},
+		"ghcr.io":         {"https://ghcr.io"},
+		"quay.io":         {"https://quay.io"},
+		"registry.k8s.io": {"https://registry.k8s.io"},
+	}
+
+	for name, urls := range defaultRegistries {
+		urlsJSON, err := json.Marshal(urls)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("INSERT INTO mirrors (name, urls, status, type) VALUES (?, ?, ?, 'oci')",
+			name, string(urlsJSON), "approved")
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,358 @@
+// Package debidx parses Debian repository metadata (Release/InRelease and
+// Packages/Sources indexes) as it passes through the proxy, so the cache can
+// answer package-level search and prefetch queries instead of only matching
+// filenames. Parsed tuples are persisted to the deb_packages/deb_releases
+// tables in the existing SQLite database.
+package debidx
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"apt-cache-proxy/internal/database"
+	"apt-cache-proxy/internal/logger"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Package is a single stanza extracted from a Packages (or Sources) index.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string
+	Filename     string
+	SHA256       string
+	Size         int64
+	Depends      string
+}
+
+// Release is the subset of an InRelease/Release file we care about.
+type Release struct {
+	Codename      string
+	Components    []string
+	Architectures []string
+}
+
+// Ingest parses the metadata file named filename (as it was fetched for
+// distro/suite/component/arch) from r and stores any extracted package
+// tuples or release metadata in the database. It is safe to call for any
+// file that passes through the proxy; files it doesn't recognize are
+// ignored.
+func Ingest(distro, suite, component, arch, filename string, r io.Reader) error {
+	log := logger.Get()
+
+	reader, err := decompress(filename, r)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(filename, "Packages"), strings.HasPrefix(filename, "Sources"):
+		pkgs, err := ParsePackages(reader)
+		if err != nil {
+			return err
+		}
+		if err := storePackages(distro, suite, component, arch, pkgs); err != nil {
+			return err
+		}
+		log.Infof("debidx: indexed %d packages from %s/%s/%s/%s", len(pkgs), distro, suite, component, arch)
+
+	case filename == "Release" || filename == "InRelease":
+		rel, err := ParseRelease(reader)
+		if err != nil {
+			return err
+		}
+		if err := storeRelease(distro, suite, rel); err != nil {
+			return err
+		}
+		log.Infof("debidx: indexed release %s/%s (codename=%s)", distro, suite, rel.Codename)
+	}
+
+	return nil
+}
+
+// IngestAndDiff behaves like Ingest for a Packages/Sources index, but also
+// reports which of the parsed tuples weren't already present in
+// deb_packages at the same (distro, suite, component, architecture,
+// package, version) - i.e. packages that just appeared in this index fetch.
+// Used by the prefetch worker (see internal/cache/prefetch.go) to enqueue
+// only genuinely new .debs instead of re-queueing an entire archive on every
+// metadata refresh. Release/InRelease files have nothing to diff against and
+// are rejected; callers should keep using Ingest for those.
+func IngestAndDiff(distro, suite, component, arch, filename string, r io.Reader) (newPkgs []Package, err error) {
+	if !(strings.HasPrefix(filename, "Packages") || strings.HasPrefix(filename, "Sources")) {
+		return nil, fmt.Errorf("debidx: IngestAndDiff called for non-index file %q", filename)
+	}
+
+	reader, err := decompress(filename, r)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs, err := ParsePackages(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	newPkgs, err = storePackagesDiff(distro, suite, component, arch, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Get().Infof("debidx: indexed %d packages (%d new) from %s/%s/%s/%s",
+		len(pkgs), len(newPkgs), distro, suite, component, arch)
+	return newPkgs, nil
+}
+
+func decompress(filename string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(filename, ".xz"):
+		return xz.NewReader(r)
+	default:
+		return r, nil
+	}
+}
+
+// ParsePackages parses an RFC822-style Packages (or Sources) index into a
+// slice of Package tuples, one per stanza.
+func ParsePackages(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	cur := Package{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	flush := func() {
+		if cur.Name != "" {
+			pkgs = append(pkgs, cur)
+		}
+		cur = Package{}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		key, value, ok := splitField(line)
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "Package":
+			cur.Name = value
+		case "Version":
+			cur.Version = value
+		case "Architecture":
+			cur.Architecture = value
+		case "Filename":
+			cur.Filename = value
+		case "SHA256":
+			cur.SHA256 = value
+		case "Size":
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+				cur.Size = size
+			}
+		case "Depends":
+			cur.Depends = value
+		}
+	}
+	flush()
+
+	return pkgs, scanner.Err()
+}
+
+// ParseRelease parses a Release/InRelease file for the fields that drive
+// prefetching (codename, components, architectures).
+func ParseRelease(r io.Reader) (Release, error) {
+	var rel Release
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, value, ok := splitField(line)
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "Codename":
+			rel.Codename = value
+		case "Components":
+			rel.Components = strings.Fields(value)
+		case "Architectures":
+			rel.Architectures = strings.Fields(value)
+		}
+	}
+
+	return rel, scanner.Err()
+}
+
+func splitField(line string) (key, value string, ok bool) {
+	if line == "" || line[0] == ' ' || line[0] == '\t' {
+		return "", "", false
+	}
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	return line[:idx], strings.TrimSpace(line[idx+1:]), true
+}
+
+func storePackages(distro, suite, component, arch string, pkgs []Package) error {
+	db := database.Get()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO deb_packages
+		(distro, suite, component, architecture, package, version, filename, sha256, size, depends)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, pkg := range pkgs {
+		if _, err := stmt.Exec(distro, suite, component, arch, pkg.Name, pkg.Version, pkg.Filename, pkg.SHA256, pkg.Size, pkg.Depends); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// storePackagesDiff upserts pkgs into deb_packages like storePackages, but
+// first checks which ones are new at their exact version, returning those as
+// newPkgs. The existence check and the upsert run in the same transaction so
+// a concurrent Ingest of the same index can't race it into missing or
+// double-counting a package.
+func storePackagesDiff(distro, suite, component, arch string, pkgs []Package) (newPkgs []Package, err error) {
+	db := database.Get()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existsStmt, err := tx.Prepare(`SELECT 1 FROM deb_packages
+		WHERE distro = ? AND suite = ? AND component = ? AND architecture = ? AND package = ? AND version = ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer existsStmt.Close()
+
+	upsertStmt, err := tx.Prepare(`INSERT OR REPLACE INTO deb_packages
+		(distro, suite, component, architecture, package, version, filename, sha256, size, depends)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer upsertStmt.Close()
+
+	for _, pkg := range pkgs {
+		var dummy int
+		switch err := existsStmt.QueryRow(distro, suite, component, arch, pkg.Name, pkg.Version).Scan(&dummy); {
+		case err == sql.ErrNoRows:
+			newPkgs = append(newPkgs, pkg)
+		case err != nil:
+			return nil, err
+		}
+
+		if _, err := upsertStmt.Exec(distro, suite, component, arch, pkg.Name, pkg.Version, pkg.Filename, pkg.SHA256, pkg.Size, pkg.Depends); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return newPkgs, nil
+}
+
+func storeRelease(distro, suite string, rel Release) error {
+	db := database.Get()
+	_, err := db.Exec(`INSERT OR REPLACE INTO deb_releases
+		(distro, suite, codename, components, architectures, fetched_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		distro, suite, rel.Codename, strings.Join(rel.Components, " "), strings.Join(rel.Architectures, " "))
+	return err
+}
+
+// Search returns packages whose name contains query (case-insensitive),
+// ordered by name and then by version string descending. Versions are
+// compared as plain strings, not by Debian version ordering, so e.g.
+// "1.9" sorts before "1.10".
+func Search(query string, limit int) ([]Package, error) {
+	db := database.Get()
+
+	rows, err := db.Query(`SELECT distro, suite, component, architecture, package, version, filename, sha256, size, depends
+		FROM deb_packages WHERE package LIKE ? ORDER BY package, version DESC LIMIT ?`,
+		"%"+query+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Package
+	for rows.Next() {
+		var distro, suite, component string
+		var pkg Package
+		if err := rows.Scan(&distro, &suite, &component, &pkg.Architecture, &pkg.Name, &pkg.Version, &pkg.Filename, &pkg.SHA256, &pkg.Size, &pkg.Depends); err != nil {
+			continue
+		}
+		results = append(results, pkg)
+	}
+
+	return results, rows.Err()
+}
+
+// LookupByFilename returns the SHA256 and Size published in distro's
+// Packages index for filename (the Filename field, i.e. the same
+// mirror-relative path used as pkgPath elsewhere in the proxy), if it's been
+// indexed. Used by the cache package to recognize when a file requested
+// under a new URL or distro codename is byte-identical to one already on
+// disk, so it can be hardlinked from the content-addressed pool instead of
+// downloaded again.
+func LookupByFilename(distro, filename string) (sha256 string, size int64, ok bool) {
+	db := database.Get()
+
+	row := db.QueryRow(`SELECT sha256, size FROM deb_packages
+		WHERE distro = ? AND filename = ? AND sha256 != '' LIMIT 1`, distro, filename)
+
+	if err := row.Scan(&sha256, &size); err != nil {
+		return "", 0, false
+	}
+	return sha256, size, true
+}
+
+// Resolve looks up a single package by name within a distro/suite, returning
+// its download Filename and SHA256 so callers can build an upstream URL.
+func Resolve(distro, suite, name string) (Package, bool) {
+	db := database.Get()
+
+	var pkg Package
+	row := db.QueryRow(`SELECT package, version, architecture, filename, sha256, size, depends
+		FROM deb_packages WHERE distro = ? AND suite = ? AND package = ?
+		ORDER BY version DESC LIMIT 1`, distro, suite, name)
+
+	if err := row.Scan(&pkg.Name, &pkg.Version, &pkg.Architecture, &pkg.Filename, &pkg.SHA256, &pkg.Size, &pkg.Depends); err != nil {
+		return Package{}, false
+	}
+
+	return pkg, true
+}
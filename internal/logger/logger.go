@@ -1,10 +1,12 @@
 package logger
 
 import (
+	"context"
 	"os"
 	"sync"
 
 	"github.com/sirupsen/logrus"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
@@ -12,7 +14,9 @@ var (
 	once sync.Once
 )
 
-// Init initializes the logger
+// Init initializes the logger with sane bootstrap defaults (text format,
+// stdout). It runs before config.Load, so config-driven settings like
+// log_format and log_file are applied later by Configure.
 func Init() {
 	once.Do(func() {
 		log = logrus.New()
@@ -25,6 +29,49 @@ func Init() {
 	})
 }
 
+// Settings holds the config-driven logging knobs Configure applies. Defined
+// here (rather than read off internal/config.Config directly) so this
+// package doesn't have to import internal/config - internal/cluster already
+// imports logger, and config imports cluster to route config.Set through
+// Raft, so a logger -> config import would close that cycle.
+type Settings struct {
+	Format     string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Level      string
+}
+
+// Configure applies config-driven logging settings (format, output file,
+// rotation) once config.Load has run. Called again by Reload so the log
+// destination picks up changes without a restart.
+func Configure(s Settings) {
+	l := Get()
+
+	if s.Format == "json" {
+		l.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		l.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	}
+
+	if s.File != "" {
+		l.SetOutput(&lumberjack.Logger{
+			Filename:   s.File,
+			MaxSize:    s.MaxSizeMB,
+			MaxBackups: s.MaxBackups,
+			MaxAge:     s.MaxAgeDays,
+		})
+	} else {
+		l.SetOutput(os.Stdout)
+	}
+
+	SetLevel(s.Level)
+}
+
 // Get returns the logger instance
 func Get() *logrus.Logger {
 	if log == nil {
@@ -49,3 +96,27 @@ func SetLevel(level string) {
 		l.SetLevel(logrus.InfoLevel)
 	}
 }
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// ContextWithRequestID attaches a per-request correlation ID to ctx, set by
+// the server's request ID middleware.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the correlation ID stashed by
+// ContextWithRequestID, or "" if none was set.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// FromContext returns a logger entry tagged with the request's correlation
+// ID, so every log line for a request - across proxy.Handler's cache hit,
+// miss, and upstream fetch paths - can be grepped out as one group.
+func FromContext(ctx context.Context) *logrus.Entry {
+	return Get().WithField("req_id", RequestIDFromContext(ctx))
+}
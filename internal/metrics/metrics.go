@@ -0,0 +1,130 @@
+// Package metrics exposes apt-cache-proxy's runtime counters as Prometheus
+// collectors, in addition to the JSON surface in internal/stats. Collectors
+// are registered once at package init; proxy, mirrors, and stats call the
+// Record* helpers so the two views never drift apart.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aptcache_requests_total",
+		Help: "Total proxy requests by distro, result, and HTTP status code.",
+	}, []string{"distro", "result", "code"})
+
+	bytesServedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aptcache_bytes_served_total",
+		Help: "Total bytes served to clients by distro and result.",
+	}, []string{"distro", "result"})
+
+	upstreamLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aptcache_upstream_latency_seconds",
+		Help:    "Latency of upstream mirror fetches and health probes, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mirror"})
+
+	cacheFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aptcache_cache_files",
+		Help: "Number of cached files per distro.",
+	}, []string{"distro"})
+
+	cacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aptcache_cache_bytes",
+		Help: "Bytes of cached files on disk per distro.",
+	}, []string{"distro"})
+
+	mirrorUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aptcache_mirror_up",
+		Help: "1 if a mirror URL's last health probe succeeded, 0 otherwise.",
+	}, []string{"name", "url"})
+
+	blacklistHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aptcache_blacklist_hits_total",
+		Help: "Requests skipped because they matched a blacklist pattern.",
+	}, []string{"pattern"})
+
+	responseSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aptcache_response_size_bytes",
+		Help:    "Size of proxy responses served to clients, by distro and result.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10), // 1KiB .. 256MiB
+	}, []string{"distro", "result"})
+
+	mirrorFetchTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aptcache_mirror_fetch_total",
+		Help: "Upstream fetch attempts per mirror URL, by result (success/failure).",
+	}, []string{"mirror", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestsTotal,
+		bytesServedTotal,
+		upstreamLatencySeconds,
+		cacheFiles,
+		cacheBytes,
+		mirrorUp,
+		blacklistHitsTotal,
+		responseSizeBytes,
+		mirrorFetchTotal,
+	)
+}
+
+// RecordRequest records the outcome of one proxy request. result is one of
+// "hit", "miss", "passthrough", or "error".
+func RecordRequest(distro, result string, code int) {
+	requestsTotal.WithLabelValues(distro, result, fmt.Sprintf("%d", code)).Inc()
+}
+
+// AddBytesServed adds n bytes to the running total for distro/result.
+func AddBytesServed(distro, result string, n int64) {
+	bytesServedTotal.WithLabelValues(distro, result).Add(float64(n))
+}
+
+// ObserveUpstreamLatency records the round-trip time of one upstream fetch
+// or health probe against a mirror URL.
+func ObserveUpstreamLatency(mirror string, seconds float64) {
+	upstreamLatencySeconds.WithLabelValues(mirror).Observe(seconds)
+}
+
+// SetCacheStats sets the cache_files/cache_bytes gauges for a distro,
+// overwriting any previous value. Called from stats.UpdateFileStats.
+func SetCacheStats(distro string, files, bytes int64) {
+	cacheFiles.WithLabelValues(distro).Set(float64(files))
+	cacheBytes.WithLabelValues(distro).Set(float64(bytes))
+}
+
+// SetMirrorUp records whether a mirror URL's most recent probe succeeded.
+func SetMirrorUp(name, url string, up bool) {
+	v := 0.0
+	if up {
+		v = 1.0
+	}
+	mirrorUp.WithLabelValues(name, url).Set(v)
+}
+
+// IncBlacklistHit records a request rejected by a blacklist pattern.
+func IncBlacklistHit(pattern string) {
+	blacklistHitsTotal.WithLabelValues(pattern).Inc()
+}
+
+// ObserveResponseSize records the size of a response served to a client.
+func ObserveResponseSize(distro, result string, bytes int64) {
+	responseSizeBytes.WithLabelValues(distro, result).Observe(float64(bytes))
+}
+
+// RecordMirrorFetch records one upstream fetch attempt against a specific
+// mirror URL. result is "success" or "failure".
+func RecordMirrorFetch(mirror, result string) {
+	mirrorFetchTotal.WithLabelValues(mirror, result).Inc()
+}
+
+// Handler returns the promhttp handler to mount at GET /metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,424 @@
+package mirrors
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"apt-cache-proxy/internal/database"
+	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/metrics"
+)
+
+// URLHealth tracks the continuously-updated health of a single mirror URL,
+// used to rank mirrors within a distro's pool and to quarantine dead ones.
+// It doubles as the circuit-breaker state used by OrderForFetch: repeated
+// failures push CooldownUntil out exponentially, and a rolling window of
+// recent outcomes/latencies drives ErrorRate and P90LatencyMS.
+type URLHealth struct {
+	LatencyMS           int64     `json:"latency_ms"`
+	LastOK              time.Time `json:"last_ok"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	BytesPerSec         float64   `json:"bytes_per_sec"`
+	Country             string    `json:"country,omitempty"`
+	ASN                 string    `json:"asn,omitempty"`
+
+	CooldownUntil time.Time `json:"cooldown_until,omitempty"`
+	ErrorRate     float64   `json:"error_rate"`
+	P90LatencyMS  int64     `json:"p90_latency_ms"`
+
+	recentOutcomes  []bool
+	recentLatencyMS []int64
+}
+
+// rollingWindow bounds how many recent outcomes/latencies feed ErrorRate and
+// P90LatencyMS, so health reflects recent behavior rather than history since
+// boot.
+const rollingWindow = 20
+
+// baseCooldown and maxCooldown bound the exponential backoff applied to a
+// mirror URL after consecutive failures: 1s, 2s, 4s, ... capped at 5m.
+const (
+	baseCooldown = 1 * time.Second
+	maxCooldown  = 5 * time.Minute
+)
+
+// GeoResolver maps a host to a coarse geographic location, e.g. backed by a
+// MaxMind GeoLite2 database. Optional: when unset, geo/ASN fields stay empty
+// and ranking falls back to latency/recency alone.
+type GeoResolver interface {
+	Resolve(host string) (country, asn string, err error)
+}
+
+var (
+	healthMu    sync.RWMutex
+	health      = map[string]*URLHealth{}
+	geoResolver GeoResolver
+)
+
+// SetGeoResolver installs the resolver used to annotate probed URLs with
+// country/ASN. Passing nil disables geo annotation.
+func SetGeoResolver(r GeoResolver) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	geoResolver = r
+}
+
+// ProbeAll checks every URL of every approved (or degraded, for recovery)
+// mirror with a small ranged GET of probePath, updating health and
+// quarantine state. Intended to be called periodically by worker.Start.
+func ProbeAll(probePath string, failureThreshold int) {
+	mu.RLock()
+	snapshot := make(map[string]Mirror, len(mirrorsCache))
+	for name, m := range mirrorsCache {
+		snapshot[name] = m
+	}
+	mu.RUnlock()
+
+	for name, mirror := range snapshot {
+		if mirror.Status != "approved" && mirror.Status != "degraded" {
+			continue
+		}
+		if mirror.Type == "oci" {
+			continue // OCI registries are probed via their own manifest HEAD, not an APT path
+		}
+		for _, rawURL := range mirror.URLs {
+			probeOne(name, rawURL, probePath, failureThreshold)
+		}
+	}
+}
+
+// ProbeMirror re-checks every URL belonging to a single named mirror, for
+// the admin "probe now" trigger.
+func ProbeMirror(name, probePath string, failureThreshold int) error {
+	mu.RLock()
+	mirror, ok := mirrorsCache[name]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown mirror: %s", name)
+	}
+
+	for _, rawURL := range mirror.URLs {
+		probeOne(name, rawURL, probePath, failureThreshold)
+	}
+	return nil
+}
+
+func probeOne(mirrorName, rawURL, probePath string, failureThreshold int) {
+	log := logger.Get()
+	target := fmt.Sprintf("%s/%s", trimSlash(rawURL), probePath)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err == nil {
+		req.Header.Set("Range", "bytes=0-65535")
+	}
+
+	start := time.Now()
+	var h URLHealth
+	success := false
+
+	if err == nil {
+		resp, reqErr := client.Do(req)
+		if reqErr == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode < 400 {
+				elapsed := time.Since(start)
+				h.LatencyMS = elapsed.Milliseconds()
+				if elapsed > 0 {
+					h.BytesPerSec = float64(resp.ContentLength) / elapsed.Seconds()
+				}
+				h.LastOK = time.Now()
+				success = true
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+
+	healthMu.Lock()
+	prev := health[rawURL]
+	if prev == nil {
+		prev = &URLHealth{}
+	}
+	if !success {
+		h.LatencyMS = prev.LatencyMS
+	}
+	if geoResolver != nil {
+		if host, parseErr := hostOf(rawURL); parseErr == nil {
+			h.Country, h.ASN, _ = geoResolver.Resolve(host)
+		}
+	} else {
+		h.Country = prev.Country
+		h.ASN = prev.ASN
+	}
+	h.ConsecutiveFailures = prev.ConsecutiveFailures
+	h.recentOutcomes = prev.recentOutcomes
+	h.recentLatencyMS = prev.recentLatencyMS
+	recordOutcome(&h, success, elapsed)
+	health[rawURL] = &h
+	healthMu.Unlock()
+
+	saveHealth(rawURL, &h)
+	metrics.ObserveUpstreamLatency(rawURL, elapsed.Seconds())
+	metrics.SetMirrorUp(mirrorName, rawURL, success)
+
+	if !success {
+		log.Warnf("Mirror probe failed: %s (%d consecutive failures, cooling down until %s)",
+			rawURL, h.ConsecutiveFailures, h.CooldownUntil.Format(time.RFC3339))
+	}
+
+	if h.ConsecutiveFailures >= failureThreshold {
+		quarantine(mirrorName)
+	} else if success {
+		unquarantine(mirrorName)
+	}
+}
+
+// RecordFetchResult feeds the outcome of an actual upstream download (as
+// opposed to a background health probe) into the same circuit breaker state,
+// so a mirror that fails real requests backs off even between probe ticks.
+// mirror is the base mirror URL (FetchTarget.Mirror), not the per-file URL
+// that was actually fetched - see FetchTarget's doc comment for why that
+// distinction matters.
+func RecordFetchResult(mirror string, success bool, latency time.Duration) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	prev := health[mirror]
+	h := URLHealth{}
+	if prev != nil {
+		h = *prev
+	}
+	recordOutcome(&h, success, latency)
+	health[mirror] = &h
+}
+
+// recordOutcome applies one success/failure observation to h: it resets or
+// grows the exponential cooldown, and appends to the rolling windows used to
+// derive ErrorRate and P90LatencyMS.
+func recordOutcome(h *URLHealth, success bool, latency time.Duration) {
+	if success {
+		h.ConsecutiveFailures = 0
+		h.CooldownUntil = time.Time{}
+		h.LastOK = time.Now()
+		h.LatencyMS = latency.Milliseconds()
+	} else {
+		h.ConsecutiveFailures++
+		cooldown := baseCooldown * time.Duration(1<<uint(minInt(h.ConsecutiveFailures-1, 32)))
+		if cooldown <= 0 || cooldown > maxCooldown {
+			cooldown = maxCooldown
+		}
+		h.CooldownUntil = time.Now().Add(cooldown)
+	}
+
+	h.recentOutcomes = append(h.recentOutcomes, success)
+	if len(h.recentOutcomes) > rollingWindow {
+		h.recentOutcomes = h.recentOutcomes[len(h.recentOutcomes)-rollingWindow:]
+	}
+	failures := 0
+	for _, ok := range h.recentOutcomes {
+		if !ok {
+			failures++
+		}
+	}
+	h.ErrorRate = float64(failures) / float64(len(h.recentOutcomes))
+
+	h.recentLatencyMS = append(h.recentLatencyMS, latency.Milliseconds())
+	if len(h.recentLatencyMS) > rollingWindow {
+		h.recentLatencyMS = h.recentLatencyMS[len(h.recentLatencyMS)-rollingWindow:]
+	}
+	h.P90LatencyMS = percentile(h.recentLatencyMS, 90)
+}
+
+// percentile returns the p-th percentile (0-100) of samples as the element
+// at index p*len/100 of the sorted samples (clamped to the last element),
+// without mutating samples.
+func percentile(samples []int64, p int) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p * len(sorted)) / 100
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func quarantine(name string) {
+	mu.Lock()
+	mirror, ok := mirrorsCache[name]
+	if ok && mirror.Status == "approved" {
+		mirror.Status = "degraded"
+		mirrorsCache[name] = mirror
+	}
+	mu.Unlock()
+}
+
+func unquarantine(name string) {
+	mu.Lock()
+	mirror, ok := mirrorsCache[name]
+	if ok && mirror.Status == "degraded" {
+		mirror.Status = "approved"
+		mirrorsCache[name] = mirror
+	}
+	mu.Unlock()
+}
+
+func saveHealth(rawURL string, h *URLHealth) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+	_, err := db.Exec(`INSERT INTO mirror_health (url, latency_ms, last_ok, consecutive_failures, bytes_per_sec, country, asn, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(url) DO UPDATE SET
+			latency_ms = excluded.latency_ms,
+			last_ok = excluded.last_ok,
+			consecutive_failures = excluded.consecutive_failures,
+			bytes_per_sec = excluded.bytes_per_sec,
+			country = excluded.country,
+			asn = excluded.asn,
+			updated_at = CURRENT_TIMESTAMP`,
+		rawURL, h.LatencyMS, h.LastOK, h.ConsecutiveFailures, h.BytesPerSec, h.Country, h.ASN)
+	if err != nil {
+		logger.Get().Warnf("Failed to persist mirror health for %s: %v", rawURL, err)
+	}
+}
+
+// GetHealth returns a snapshot of the current health map, keyed by URL, for
+// the admin API and Prometheus metrics endpoint.
+func GetHealth() map[string]URLHealth {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+
+	result := make(map[string]URLHealth, len(health))
+	for url, h := range health {
+		result[url] = *h
+	}
+	return result
+}
+
+// FetchTarget pairs a fully-qualified URL to fetch (mirror base + pkgPath)
+// with the base mirror identity it belongs to. The circuit-breaker state in
+// this file, the chaos fault lookups in internal/chaos, and the
+// {"mirror"}-labeled Prometheus series in internal/metrics are all keyed by
+// Mirror - the same base URL ProbeAll/rankURLs key health by - not by URL,
+// which is unique per file and would otherwise shard that state across an
+// unbounded number of effectively-never-repeated keys.
+type FetchTarget struct {
+	URL    string
+	Mirror string
+}
+
+// OrderForFetch orders targets for a single upstream fetch attempt: targets
+// whose Mirror is still cooling down from recent failures are moved to the
+// back (so a dead mirror stops eating a retry slot on every request), and
+// the rest are sorted by rolling error rate then P90 latency so the fastest
+// healthy mirror is tried first. If every target is cooling down, they're
+// tried in order of earliest cooldown expiry instead of giving up.
+func OrderForFetch(targets []FetchTarget) []FetchTarget {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+
+	now := time.Now()
+	var ready, cooling []FetchTarget
+	for _, t := range targets {
+		if h := health[t.Mirror]; h != nil && h.CooldownUntil.After(now) {
+			cooling = append(cooling, t)
+		} else {
+			ready = append(ready, t)
+		}
+	}
+
+	sort.SliceStable(ready, func(i, j int) bool {
+		return fetchScore(health[ready[i].Mirror]) < fetchScore(health[ready[j].Mirror])
+	})
+
+	if len(ready) > 0 {
+		return append(ready, sortByCooldownExpiry(cooling)...)
+	}
+	return sortByCooldownExpiry(cooling)
+}
+
+func sortByCooldownExpiry(targets []FetchTarget) []FetchTarget {
+	sorted := make([]FetchTarget, len(targets))
+	copy(sorted, targets)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return health[sorted[i].Mirror].CooldownUntil.Before(health[sorted[j].Mirror].CooldownUntil)
+	})
+	return sorted
+}
+
+// fetchScore is lower-is-better: unprobed URLs sort as neutral, and among
+// probed URLs a lower rolling error rate wins, ties broken by P90 latency.
+func fetchScore(h *URLHealth) float64 {
+	if h == nil {
+		return 0
+	}
+	return h.ErrorRate*1e6 + float64(h.P90LatencyMS)
+}
+
+// rankURLs sorts urls by health score (healthy + low latency first),
+// preferring PreferredCountry when set and known for a URL.
+func rankURLs(urls []string, preferredCountry string) []string {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+
+	ranked := make([]string, len(urls))
+	copy(ranked, urls)
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		hi, hj := health[ranked[i]], health[ranked[j]]
+		return score(hi, ranked[i], preferredCountry) < score(hj, ranked[j], preferredCountry)
+	})
+
+	return ranked
+}
+
+// score is lower-is-better: unprobed URLs sort as neutral (tried in original
+// order), probed-but-failing URLs sort last, and among healthy URLs the
+// fastest (and, if preferredCountry matches, geographically closest) wins.
+func score(h *URLHealth, rawURL, preferredCountry string) float64 {
+	if h == nil {
+		return 0
+	}
+
+	s := float64(h.LatencyMS)
+	s += float64(h.ConsecutiveFailures) * 10000
+
+	if preferredCountry != "" && h.Country != "" && h.Country != preferredCountry {
+		s += 5000
+	}
+
+	return s
+}
+
+func trimSlash(u string) string {
+	for len(u) > 0 && u[len(u)-1] == '/' {
+		u = u[:len(u)-1]
+	}
+	return u
+}
+
+func hostOf(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
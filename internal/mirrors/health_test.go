@@ -0,0 +1,108 @@
+package mirrors
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordOutcomeCooldownBackoff(t *testing.T) {
+	h := &URLHealth{}
+
+	wantCooldowns := []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+	}
+
+	for i, want := range wantCooldowns {
+		before := time.Now()
+		recordOutcome(h, false, 0)
+		if h.ConsecutiveFailures != i+1 {
+			t.Fatalf("failure %d: ConsecutiveFailures = %d, want %d", i+1, h.ConsecutiveFailures, i+1)
+		}
+		got := h.CooldownUntil.Sub(before)
+		if got < want || got > want+time.Second {
+			t.Errorf("failure %d: cooldown ~= %v, want ~%v", i+1, got, want)
+		}
+	}
+}
+
+func TestRecordOutcomeCooldownCapsAtMax(t *testing.T) {
+	h := &URLHealth{}
+	for i := 0; i < 32; i++ {
+		recordOutcome(h, false, 0)
+	}
+	before := time.Now()
+	recordOutcome(h, false, 0)
+	if got := h.CooldownUntil.Sub(before); got > maxCooldown+time.Second {
+		t.Errorf("cooldown after many failures = %v, want capped at ~%v", got, maxCooldown)
+	}
+}
+
+func TestRecordOutcomeSuccessResetsCooldown(t *testing.T) {
+	h := &URLHealth{}
+	recordOutcome(h, false, 0)
+	recordOutcome(h, false, 0)
+	if h.ConsecutiveFailures == 0 {
+		t.Fatal("expected consecutive failures to be recorded before success")
+	}
+
+	recordOutcome(h, true, 5*time.Millisecond)
+	if h.ConsecutiveFailures != 0 {
+		t.Errorf("ConsecutiveFailures after success = %d, want 0", h.ConsecutiveFailures)
+	}
+	if !h.CooldownUntil.IsZero() {
+		t.Errorf("CooldownUntil after success = %v, want zero", h.CooldownUntil)
+	}
+}
+
+func TestRecordOutcomeErrorRate(t *testing.T) {
+	h := &URLHealth{}
+	for i := 0; i < 3; i++ {
+		recordOutcome(h, true, time.Millisecond)
+	}
+	for i := 0; i < 1; i++ {
+		recordOutcome(h, false, 0)
+	}
+
+	want := 1.0 / 4.0
+	if h.ErrorRate != want {
+		t.Errorf("ErrorRate = %v, want %v", h.ErrorRate, want)
+	}
+}
+
+func TestRecordOutcomeRollingWindowTrims(t *testing.T) {
+	h := &URLHealth{}
+	for i := 0; i < rollingWindow+5; i++ {
+		recordOutcome(h, i%2 == 0, time.Millisecond)
+	}
+	if len(h.recentOutcomes) != rollingWindow {
+		t.Errorf("len(recentOutcomes) = %d, want %d", len(h.recentOutcomes), rollingWindow)
+	}
+	if len(h.recentLatencyMS) != rollingWindow {
+		t.Errorf("len(recentLatencyMS) = %d, want %d", len(h.recentLatencyMS), rollingWindow)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	cases := []struct {
+		name    string
+		samples []int64
+		p       int
+		want    int64
+	}{
+		{"empty", nil, 90, 0},
+		{"single value", []int64{42}, 90, 42},
+		{"unsorted input", []int64{30, 10, 20}, 50, 20},
+		{"p90 of ten values", []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 90, 10},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.samples, tc.p); got != tc.want {
+				t.Errorf("percentile(%v, %d) = %d, want %d", tc.samples, tc.p, got, tc.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"apt-cache-proxy/internal/cluster"
+	"apt-cache-proxy/internal/config"
 	"apt-cache-proxy/internal/database"
 	"apt-cache-proxy/internal/logger"
 )
@@ -15,6 +17,9 @@ import (
 type Mirror struct {
 	URLs   []string `json:"urls"`
 	Status string   `json:"status"`
+	// Type discriminates an APT repository mirror ("apt", the default) from
+	// an OCI/Docker registry mirror ("oci"); see internal/ociproxy.
+	Type string `json:"type"`
 }
 
 var (
@@ -24,6 +29,68 @@ var (
 
 func init() {
 	mirrorsCache = make(map[string]Mirror)
+
+	cluster.Register("mirrors.save", applySaveCmd)
+	cluster.Register("mirrors.update", applyUpdateCmd)
+	cluster.Register("mirrors.delete", applyDeleteCmd)
+	cluster.RegisterSnapshot("mirrors", snapshotMirrors, restoreMirrors)
+}
+
+// snapshotMirrors serializes the full mirrorsCache for cluster.Snapshot, so a
+// node that joins after a Raft log truncation (or restores on restart) still
+// ends up with every mirror instead of just whatever mirrors.* commands
+// happened to land in the log afterward.
+func snapshotMirrors() ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return json.Marshal(mirrorsCache)
+}
+
+// restoreMirrors replaces mirrorsCache wholesale from a snapshot captured by
+// snapshotMirrors, and persists it to the local database so it survives a
+// plain process restart the same way LoadFromDB expects.
+func restoreMirrors(data []byte) error {
+	var snap map[string]Mirror
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	db := database.Get()
+	if _, err := db.Exec("DELETE FROM mirrors"); err != nil {
+		return err
+	}
+	for name, m := range snap {
+		urlsJSON, err := json.Marshal(m.URLs)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT OR REPLACE INTO mirrors (name, urls, status, type) VALUES (?, ?, ?, ?)",
+			name, string(urlsJSON), m.Status, m.Type); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	mirrorsCache = snap
+	mu.Unlock()
+	return nil
+}
+
+type saveCmd struct {
+	Name   string   `json:"name"`
+	URLs   []string `json:"urls"`
+	Status string   `json:"status"`
+	Type   string   `json:"type"`
+}
+
+type updateCmd struct {
+	Name   string   `json:"name"`
+	URLs   []string `json:"urls"`
+	Status string   `json:"status"`
+}
+
+type deleteCmd struct {
+	Name string `json:"name"`
 }
 
 // LoadFromDB loads mirrors from database
@@ -31,31 +98,32 @@ func LoadFromDB() error {
 	db := database.Get()
 	log := logger.Get()
 	
-	rows, err := db.Query("SELECT name, urls, status FROM mirrors")
+	rows, err := db.Query("SELECT name, urls, status, type FROM mirrors")
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
-	
+
 	mu.Lock()
 	defer mu.Unlock()
-	
+
 	mirrorsCache = make(map[string]Mirror)
-	
+
 	for rows.Next() {
-		var name, urlsJSON, status string
-		if err := rows.Scan(&name, &urlsJSON, &status); err != nil {
+		var name, urlsJSON, status, mirrorType string
+		if err := rows.Scan(&name, &urlsJSON, &status, &mirrorType); err != nil {
 			continue
 		}
-		
+
 		var urls []string
 		if err := json.Unmarshal([]byte(urlsJSON), &urls); err != nil {
 			continue
 		}
-		
+
 		mirrorsCache[name] = Mirror{
 			URLs:   urls,
 			Status: status,
+			Type:   mirrorType,
 		}
 	}
 	
@@ -63,15 +131,25 @@ func LoadFromDB() error {
 	return nil
 }
 
-// GetAll returns all approved mirrors
+// GetAll returns all approved mirrors, with each mirror's URLs ranked by the
+// continuous health-checker so the fastest healthy upstream is tried first.
 func GetAll() map[string][]string {
 	mu.RLock()
-	defer mu.RUnlock()
-	
-	result := make(map[string][]string)
+	snapshot := make(map[string]Mirror, len(mirrorsCache))
 	for name, mirror := range mirrorsCache {
+		snapshot[name] = mirror
+	}
+	mu.RUnlock()
+
+	preferredCountry := ""
+	if cfg := config.Get(); cfg != nil {
+		preferredCountry = cfg.PreferredCountry
+	}
+
+	result := make(map[string][]string)
+	for name, mirror := range snapshot {
 		if mirror.Status == "approved" {
-			result[name] = mirror.URLs
+			result[name] = rankURLs(mirror.URLs, preferredCountry)
 		}
 	}
 	return result
@@ -89,6 +167,25 @@ func GetAllWithStatus() map[string]Mirror {
 	return result
 }
 
+// GetOCIUpstream returns the ranked upstream URLs configured for an OCI
+// registry mirror (type "oci") matching registryHost, e.g. "docker.io".
+func GetOCIUpstream(registryHost string) ([]string, bool) {
+	mu.RLock()
+	mirror, ok := mirrorsCache[registryHost]
+	mu.RUnlock()
+
+	if !ok || mirror.Type != "oci" || mirror.Status != "approved" {
+		return nil, false
+	}
+
+	preferredCountry := ""
+	if cfg := config.Get(); cfg != nil {
+		preferredCountry = cfg.PreferredCountry
+	}
+
+	return rankURLs(mirror.URLs, preferredCountry), true
+}
+
 // GetUpstreamKey determines the upstream key from distro and path
 func GetUpstreamKey(distro, pkgPath string) string {
 	// Handle Ubuntu releases/pockets
@@ -106,92 +203,129 @@ func GetUpstreamKey(distro, pkgPath string) string {
 	return distro
 }
 
-// Save saves a mirror to database
+// Save saves an APT mirror to database. On a cluster this is routed through
+// Raft so every node converges on the same mirror list; see internal/cluster.
 func Save(name string, urls []string, status string) error {
+	return SaveTyped(name, urls, status, "apt")
+}
+
+// SaveTyped saves a mirror of the given type ("apt" or "oci"). OCI registry
+// roots are skipped from the HEAD-based reachability check since most
+// registries reject anonymous requests to "/".
+func SaveTyped(name string, urls []string, status, mirrorType string) error {
 	// Validate: check for self-reference
 	if isSelf(name) {
 		log := logger.Get()
 		log.Warnf("Skipping self-referencing mirror: %s", name)
 		return nil
 	}
-	
+
+	if mirrorType == "" {
+		mirrorType = "apt"
+	}
+
 	// Validate URLs
 	validURLs := []string{}
 	for _, url := range urls {
-		if validateMirror(url) {
+		if mirrorType == "oci" || validateMirror(url) {
 			validURLs = append(validURLs, url)
 		}
 	}
-	
+
 	if len(validURLs) == 0 {
 		return nil
 	}
-	
-	urlsJSON, err := json.Marshal(validURLs)
+
+	return cluster.Apply("mirrors.save", saveCmd{Name: name, URLs: validURLs, Status: status, Type: mirrorType})
+}
+
+func applySaveCmd(payload []byte) error {
+	var cmd saveCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
+	urlsJSON, err := json.Marshal(cmd.URLs)
 	if err != nil {
 		return err
 	}
-	
+
 	db := database.Get()
-	_, err = db.Exec("INSERT OR REPLACE INTO mirrors (name, urls, status) VALUES (?, ?, ?)",
-		name, string(urlsJSON), status)
+	_, err = db.Exec("INSERT OR REPLACE INTO mirrors (name, urls, status, type) VALUES (?, ?, ?, ?)",
+		cmd.Name, string(urlsJSON), cmd.Status, cmd.Type)
 	if err != nil {
 		return err
 	}
-	
-	// Update cache
+
 	mu.Lock()
-	mirrorsCache[name] = Mirror{
-		URLs:   validURLs,
-		Status: status,
+	mirrorsCache[cmd.Name] = Mirror{
+		URLs:   cmd.URLs,
+		Status: cmd.Status,
+		Type:   cmd.Type,
 	}
 	mu.Unlock()
-	
+
 	log := logger.Get()
-	log.Infof("Saved mirror: %s (%d URLs, status: %s)", name, len(validURLs), status)
+	log.Infof("Saved mirror: %s (%d URLs, status: %s)", cmd.Name, len(cmd.URLs), cmd.Status)
 	return nil
 }
 
 // Update updates a mirror's URLs or status
 func Update(name string, urls []string, status string) error {
+	return cluster.Apply("mirrors.update", updateCmd{Name: name, URLs: urls, Status: status})
+}
+
+func applyUpdateCmd(payload []byte) error {
+	var cmd updateCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
 	db := database.Get()
-	
-	if urls != nil {
-		urlsJSON, err := json.Marshal(urls)
+
+	if cmd.URLs != nil {
+		urlsJSON, err := json.Marshal(cmd.URLs)
 		if err != nil {
 			return err
 		}
-		_, err = db.Exec("UPDATE mirrors SET urls = ? WHERE name = ?", string(urlsJSON), name)
-		if err != nil {
+		if _, err := db.Exec("UPDATE mirrors SET urls = ? WHERE name = ?", string(urlsJSON), cmd.Name); err != nil {
 			return err
 		}
 	}
-	
-	if status != "" {
-		_, err := db.Exec("UPDATE mirrors SET status = ? WHERE name = ?", status, name)
-		if err != nil {
+
+	if cmd.Status != "" {
+		if _, err := db.Exec("UPDATE mirrors SET status = ? WHERE name = ?", cmd.Status, cmd.Name); err != nil {
 			return err
 		}
 	}
-	
+
 	// Reload from DB
 	return LoadFromDB()
 }
 
 // Delete deletes a mirror
 func Delete(name string) error {
+	return cluster.Apply("mirrors.delete", deleteCmd{Name: name})
+}
+
+func applyDeleteCmd(payload []byte) error {
+	var cmd deleteCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return err
+	}
+
 	db := database.Get()
-	_, err := db.Exec("DELETE FROM mirrors WHERE name = ?", name)
+	_, err := db.Exec("DELETE FROM mirrors WHERE name = ?", cmd.Name)
 	if err != nil {
 		return err
 	}
-	
+
 	mu.Lock()
-	delete(mirrorsCache, name)
+	delete(mirrorsCache, cmd.Name)
 	mu.Unlock()
-	
+
 	log := logger.Get()
-	log.Infof("Deleted mirror: %s", name)
+	log.Infof("Deleted mirror: %s", cmd.Name)
 	return nil
 }
 
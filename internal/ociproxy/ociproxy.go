@@ -0,0 +1,295 @@
+// Package ociproxy implements a pull-through cache for the OCI Distribution
+// Spec (the subset docker/podman/containerd need for anonymous pulls), so
+// apt-cache-proxy can also mirror container images from docker.io, ghcr.io,
+// quay.io, and registry.k8s.io. Blobs are content-addressed and immutable,
+// so they never expire; manifests are revalidated against cache_days like
+// any other cached file.
+package ociproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/database"
+	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/mirrors"
+)
+
+// Handler serves the OCI Distribution Spec pull-through routes.
+type Handler struct {
+	client *http.Client
+}
+
+// NewHandler creates an OCI pull-through handler.
+func NewHandler() *Handler {
+	return &Handler{
+		client: &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// HandleBase answers GET /v2/ with the empty object the spec requires to
+// signal API support.
+func (h *Handler) HandleBase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// HandleManifests serves GET /v2/<name>/manifests/<ref>, fetching from the
+// upstream registry on a miss and caching the manifest body on disk.
+func (h *Handler) HandleManifests(w http.ResponseWriter, r *http.Request, registry, name, ref string) {
+	log := logger.Get()
+
+	upstreams, ok := mirrors.GetOCIUpstream(registry)
+	if !ok || len(upstreams) == 0 {
+		http.Error(w, "Unknown or unconfigured registry", http.StatusNotFound)
+		return
+	}
+
+	cachePath := manifestCachePath(registry, name, ref)
+	if isManifestCacheValid(cachePath) {
+		serveManifestFromCache(w, cachePath)
+		return
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = "application/vnd.docker.distribution.manifest.v2+json,application/vnd.oci.image.manifest.v1+json"
+	}
+
+	body, contentType, digest, err := h.fetch(upstreams[0], fmt.Sprintf("/v2/%s/manifests/%s", name, ref), accept)
+	if err != nil {
+		log.Warnf("OCI manifest fetch failed for %s/%s:%s: %v", registry, name, ref, err)
+		http.Error(w, "Failed to fetch manifest from upstream", http.StatusBadGateway)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+		os.WriteFile(cachePath, body, 0644)
+	}
+	recordManifest(registry, name, ref, digest, contentType)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Write(body)
+}
+
+// HandleBlobs serves GET /v2/<name>/blobs/<digest> from the content-
+// addressed store, pulling through from upstream on a miss.
+func (h *Handler) HandleBlobs(w http.ResponseWriter, r *http.Request, registry, name, digest string) {
+	log := logger.Get()
+
+	blobPath, err := blobCachePath(digest)
+	if err != nil {
+		http.Error(w, "Invalid digest", http.StatusBadRequest)
+		return
+	}
+
+	if info, err := os.Stat(blobPath); err == nil {
+		f, err := os.Open(blobPath)
+		if err != nil {
+			http.Error(w, "Error reading cached blob", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Docker-Content-Digest", digest)
+		http.ServeContent(w, r, digest, info.ModTime(), f)
+		return
+	}
+
+	upstreams, ok := mirrors.GetOCIUpstream(registry)
+	if !ok || len(upstreams) == 0 {
+		http.Error(w, "Unknown or unconfigured registry", http.StatusNotFound)
+		return
+	}
+
+	body, _, _, err := h.fetch(upstreams[0], fmt.Sprintf("/v2/%s/blobs/%s", name, digest), "*/*")
+	if err != nil {
+		log.Warnf("OCI blob fetch failed for %s/%s@%s: %v", registry, name, digest, err)
+		http.Error(w, "Failed to fetch blob from upstream", http.StatusBadGateway)
+		return
+	}
+
+	if sum := sha256.Sum256(body); digest == "sha256:"+hex.EncodeToString(sum[:]) {
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err == nil {
+			os.WriteFile(blobPath, body, 0644)
+		}
+	} else {
+		log.Warnf("OCI blob digest mismatch for %s, not caching", digest)
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Write(body)
+}
+
+// fetch issues an authenticated GET against the upstream registry,
+// negotiating a Bearer token per the distribution spec's WWW-Authenticate
+// challenge when the anonymous request is rejected.
+func (h *Handler) fetch(upstream, path, accept string) (body []byte, contentType, digest string, err error) {
+	url := strings.TrimSuffix(upstream, "/") + path
+
+	resp, err := h.doAuthenticated(url, accept, "")
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, tokenErr := negotiateToken(h.client, resp.Header.Get("Www-Authenticate"))
+		if tokenErr != nil {
+			return nil, "", "", tokenErr
+		}
+		resp.Body.Close()
+
+		resp, err = h.doAuthenticated(url, accept, token)
+		if err != nil {
+			return nil, "", "", err
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, "", "", fmt.Errorf("upstream returned HTTP %d", resp.StatusCode)
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return body, resp.Header.Get("Content-Type"), resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+func (h *Handler) doAuthenticated(url, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return h.client.Do(req)
+}
+
+func blobCachePath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || len(parts[1]) < 2 {
+		return "", fmt.Errorf("unsupported digest: %s", digest)
+	}
+	hash := parts[1]
+	cfg := config.Get()
+	return filepath.Join(cfg.StoragePathResolved, "oci", "blobs", "sha256", hash), nil
+}
+
+func manifestCachePath(registry, name, ref string) string {
+	cfg := config.Get()
+	safeName := strings.ReplaceAll(name, "/", "_")
+	safeRef := strings.ReplaceAll(ref, ":", "_")
+	return filepath.Join(cfg.StoragePathResolved, "oci", "manifests", registry, safeName, safeRef+".json")
+}
+
+func isManifestCacheValid(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	cfg := config.Get()
+	if !cfg.CacheRetentionEnabled {
+		return true
+	}
+	age := time.Since(info.ModTime())
+	return age < time.Duration(cfg.CacheDays)*24*time.Hour
+}
+
+func serveManifestFromCache(w http.ResponseWriter, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, "Error reading cached manifest", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Write(data)
+}
+
+func recordManifest(registry, name, ref, digest, contentType string) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+	_, err := db.Exec(`INSERT OR REPLACE INTO oci_manifests (registry, name, reference, digest, content_type, cached_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`, registry, name, ref, digest, contentType)
+	if err != nil {
+		logger.Get().Warnf("Failed to record OCI manifest %s/%s:%s: %v", registry, name, ref, err)
+	}
+}
+
+// authChallenge is the parsed form of a WWW-Authenticate: Bearer header.
+type authChallenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+func parseAuthChallenge(header string) authChallenge {
+	var c authChallenge
+	header = strings.TrimPrefix(header, "Bearer ")
+	for _, field := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "realm":
+			c.realm = value
+		case "service":
+			c.service = value
+		case "scope":
+			c.scope = value
+		}
+	}
+	return c
+}
+
+func negotiateToken(client *http.Client, wwwAuthenticate string) (string, error) {
+	challenge := parseAuthChallenge(wwwAuthenticate)
+	if challenge.realm == "" {
+		return "", fmt.Errorf("missing auth realm in challenge: %s", wwwAuthenticate)
+	}
+
+	url := fmt.Sprintf("%s?service=%s&scope=%s", challenge.realm, challenge.service, challenge.scope)
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("token endpoint returned HTTP %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
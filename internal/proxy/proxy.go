@@ -6,14 +6,21 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"apt-cache-proxy/internal/cache"
+	"apt-cache-proxy/internal/chaos"
 	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/debidx"
 	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/metrics"
 	"apt-cache-proxy/internal/mirrors"
 	"apt-cache-proxy/internal/stats"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Handler struct{}
@@ -69,16 +76,27 @@ func (h *Handler) HandleAll(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handlePackage(w http.ResponseWriter, r *http.Request, distro, pkgPath string) {
 	stats.IncrementRequests()
-	
-	log := logger.Get()
+	start := time.Now()
+
+	log := logger.FromContext(r.Context())
 	log.Infof("Request: /%s/%s", distro, pkgPath)
 	stats.AddLog(fmt.Sprintf("Request: /%s/%s", distro, pkgPath), "INFO")
 
 	// Check cache
 	cachePath := cache.GetCachePath(distro, pkgPath)
+	if !cache.IsCacheValid(cachePath) {
+		// This exact URL may be a miss, but if the distro's Packages index
+		// already told us its SHA256 and we've cached identical bytes under
+		// a different mirror path or distro codename, reuse that instead of
+		// going to upstream at all.
+		cache.TryDedupeFromPool(distro, pkgPath, cachePath)
+	}
 	if cache.IsCacheValid(cachePath) {
 		stats.IncrementCacheHits()
-		h.serveFromCache(w, r, cachePath)
+		metrics.RecordRequest(distro, "hit", http.StatusOK)
+		written := h.serveFromCache(w, r, distro, cachePath)
+		indexIfMetadata(distro, pkgPath, cachePath)
+		logAccess(log, r, http.StatusOK, written, "", time.Since(start))
 		return
 	}
 
@@ -99,10 +117,16 @@ func (h *Handler) handlePackage(w http.ResponseWriter, r *http.Request, distro,
 		}
 	}
 
-	// Build full URLs
-	upstreamURLs := make([]string, len(mirrorURLs))
+	// Build full URLs, each paired with the base mirror it came from so
+	// health/metrics/chaos state (all keyed by base mirror) doesn't end up
+	// sharded across one effectively-unique key per file (see
+	// mirrors.FetchTarget).
+	upstreamTargets := make([]mirrors.FetchTarget, len(mirrorURLs))
 	for i, mirror := range mirrorURLs {
-		upstreamURLs[i] = fmt.Sprintf("%s/%s", strings.TrimSuffix(mirror, "/"), pkgPath)
+		upstreamTargets[i] = mirrors.FetchTarget{
+			URL:    fmt.Sprintf("%s/%s", strings.TrimSuffix(mirror, "/"), pkgPath),
+			Mirror: mirror,
+		}
 	}
 
 	log.Infof("MISS: %s -> %s", pkgPath, upstreamKey)
@@ -116,8 +140,38 @@ func (h *Handler) handlePackage(w http.ResponseWriter, r *http.Request, distro,
 		}
 	}
 
-	// Download and cache (this happens in a goroutine internally for streaming)
-	resp, err := cache.StreamAndCache(upstreamURLs, cachePath, headers)
+	// A Range request on a miss gets a segmented, resumable fetch (see
+	// cache.StreamRange) instead of the ordinary full-file download, so a
+	// client pulling a byte range doesn't poison the cache with a partial
+	// file. Multi-range/suffix requests fall back to an ordinary full fetch
+	// - we drop the Range header first so upstream sends the whole file
+	// instead of a 206 that would otherwise get cached as if it were
+	// complete.
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		if reqRange, err := cache.ParseRangeHeader(rangeHeader); err == nil {
+			h.handleRangeMiss(w, r, log, start, distro, cachePath, upstreamTargets, headers, reqRange)
+			return
+		}
+		delete(headers, "Range")
+	}
+
+	// Only write the file to cache once a URL has been requested
+	// cfg.CacheAfter times, so a single one-shot download (e.g. a PPA .deb
+	// pulled once) doesn't evict more useful entries from the cache.
+	cfg := config.Get()
+	hits, err := cache.RecordAccess(distro, pkgPath)
+	if err != nil {
+		log.Warnf("Failed to record access count for %s/%s: %v", distro, pkgPath, err)
+		hits = int64(cfg.CacheAfter)
+	}
+
+	var resp *http.Response
+	var mirror string
+	if cache.ShouldCache(hits, cfg.CacheAfter) {
+		resp, mirror, err = cache.StreamAndCache(upstreamTargets, cachePath, headers, distro, pkgPath)
+	} else {
+		resp, mirror, err = cache.StreamPassthrough(upstreamTargets, headers)
+	}
 	if err != nil {
 		// Check if it's a DNS error
 		if strings.Contains(err.Error(), "DNS resolution failed") {
@@ -127,6 +181,8 @@ func (h *Handler) handlePackage(w http.ResponseWriter, r *http.Request, distro,
 			log.Errorf("Download failed: %v", err)
 			http.Error(w, "Failed to download from upstream", http.StatusBadGateway)
 		}
+		metrics.RecordRequest(distro, "error", http.StatusBadGateway)
+		logAccess(log, r, http.StatusBadGateway, 0, mirror, time.Since(start))
 		return
 	}
 	defer resp.Body.Close()
@@ -139,61 +195,209 @@ func (h *Handler) handlePackage(w http.ResponseWriter, r *http.Request, distro,
 	}
 
 	w.WriteHeader(resp.StatusCode)
-	
-	// Stream response to client (this also writes to cache via TeeReader)
-	written, err := io.Copy(w, resp.Body)
+	metrics.RecordRequest(distro, "miss", resp.StatusCode)
+
+	// Stream response to client. Once hits >= cfg.CacheAfter the body is read
+	// back from the file cache.StreamAndCache has already written (or, for a
+	// request that joined an in-progress download, from its temp file as it
+	// grows); below the threshold it's a direct passthrough with nothing
+	// written to disk. Throttled to chaos.client_bandwidth_kbps when
+	// configured so tests can reproduce a slow apt client.
+	written, err := chaos.CopyToClient(w, resp.Body, chaos.ClientBandwidthKbps())
 	if err != nil {
 		// Only log if it's not a broken pipe (client disconnected)
-		if !strings.Contains(err.Error(), "broken pipe") && 
+		if !strings.Contains(err.Error(), "broken pipe") &&
 		   !strings.Contains(err.Error(), "connection reset") {
 			log.Warnf("Error streaming response: %v", err)
 		}
 		return
 	}
-	
+
 	stats.AddBytesServed(written)
+	metrics.AddBytesServed(distro, "miss", written)
+	metrics.ObserveResponseSize(distro, "miss", written)
+	if cache.ShouldCache(hits, cfg.CacheAfter) {
+		indexIfMetadata(distro, pkgPath, cachePath)
+	}
+	logAccess(log, r, resp.StatusCode, written, mirror, time.Since(start))
 }
 
-func (h *Handler) serveFromCache(w http.ResponseWriter, r *http.Request, cachePath string) {
-	log := logger.Get()
+// handleRangeMiss serves a single-range client GET that missed the cache (or
+// hit a still-partial entry), via cache.StreamRange's segmented, resumable
+// fetch. Bypasses the cfg.CacheAfter admission policy in handlePackage -
+// range requests are about resuming/partially fetching a specific file apt
+// already decided it wants, not the one-shot-pollution problem that policy
+// targets.
+func (h *Handler) handleRangeMiss(w http.ResponseWriter, r *http.Request, log *logrus.Entry, start time.Time, distro, cachePath string, upstreamTargets []mirrors.FetchTarget, headers map[string]string, reqRange cache.ParsedRange) {
+	data, total, contentType, mirror, err := cache.StreamRange(upstreamTargets, cachePath, headers, reqRange)
+	if err != nil {
+		log.Errorf("Range fetch failed: %v", err)
+		http.Error(w, "Failed to download range from upstream", http.StatusBadGateway)
+		metrics.RecordRequest(distro, "error", http.StatusBadGateway)
+		logAccess(log, r, http.StatusBadGateway, 0, mirror, time.Since(start))
+		return
+	}
+	defer data.Close()
+
+	end := reqRange.End
+	if end < 0 || end >= total {
+		end = total - 1
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", reqRange.Start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-reqRange.Start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	written, err := io.Copy(w, data)
+	if err != nil && !strings.Contains(err.Error(), "broken pipe") && !strings.Contains(err.Error(), "connection reset") {
+		log.Warnf("Error streaming range response: %v", err)
+	}
+
+	stats.AddBytesServed(written)
+	metrics.RecordRequest(distro, "miss", http.StatusPartialContent)
+	metrics.AddBytesServed(distro, "miss", written)
+	metrics.ObserveResponseSize(distro, "miss", written)
+	logAccess(log, r, http.StatusPartialContent, written, mirror, time.Since(start))
+}
+
+// logAccess emits a single structured line per completed proxy request,
+// carrying everything needed to reconstruct what happened without cross-
+// referencing the per-event log lines above: method, path, status, bytes,
+// the upstream mirror used (empty on a cache hit or passthrough), and
+// duration. log is expected to already carry the request's req_id field.
+func logAccess(log *logrus.Entry, r *http.Request, status int, bytes int64, mirror string, duration time.Duration) {
+	log.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"status":   status,
+		"bytes":    bytes,
+		"mirror":   mirror,
+		"duration": duration.String(),
+	}).Info("access")
+}
+
+// indexIfMetadata hands Packages/Sources/Release files to debidx so the
+// proxy's search and prefetch endpoints can work off real package names
+// instead of cache filenames. Runs in the background since it re-reads the
+// file that was just written and shouldn't hold up the client response.
+func indexIfMetadata(distro, pkgPath, cachePath string) {
+	filename := path.Base(pkgPath)
+	if !isMetadataFilename(filename) {
+		return
+	}
+
+	go func() {
+		log := logger.Get()
+
+		f, err := os.Open(cachePath)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		suite, component, arch := parseAptPath(pkgPath)
+
+		if strings.HasPrefix(filename, "Packages") || strings.HasPrefix(filename, "Sources") {
+			newPkgs, err := debidx.IngestAndDiff(distro, suite, component, arch, filename, f)
+			if err != nil {
+				log.Warnf("debidx: failed to index %s/%s: %v", distro, pkgPath, err)
+				return
+			}
+			cache.EnqueuePrefetch(distro, suite, newPkgs)
+			return
+		}
+
+		if err := debidx.Ingest(distro, suite, component, arch, filename, f); err != nil {
+			log.Warnf("debidx: failed to index %s/%s: %v", distro, pkgPath, err)
+		}
+	}()
+}
+
+func isMetadataFilename(filename string) bool {
+	return strings.HasPrefix(filename, "Packages") || strings.HasPrefix(filename, "Sources") ||
+		filename == "Release" || filename == "InRelease"
+}
+
+// parseAptPath extracts suite/component/arch from a standard APT repository
+// layout, e.g. "dists/bookworm/main/binary-amd64/Packages.gz".
+func parseAptPath(pkgPath string) (suite, component, arch string) {
+	parts := strings.Split(pkgPath, "/")
+	for i, part := range parts {
+		if part != "dists" || i+1 >= len(parts) {
+			continue
+		}
+		suite = parts[i+1]
+		if i+2 < len(parts) {
+			component = parts[i+2]
+		}
+		if i+3 < len(parts) {
+			arch = strings.TrimPrefix(parts[i+3], "binary-")
+		}
+		break
+	}
+	return suite, component, arch
+}
+
+func (h *Handler) serveFromCache(w http.ResponseWriter, r *http.Request, distro, cachePath string) int64 {
+	log := logger.FromContext(r.Context())
 	log.Infof("Serving from cache: %s", cachePath)
 	stats.AddLog(fmt.Sprintf("HIT: %s", cachePath), "SUCCESS")
 
-	file, err := os.Open(cachePath)
+	key, err := cache.RelKey(cachePath)
 	if err != nil {
-		log.Errorf("Error reading cache: %v", err)
+		log.Errorf("Error resolving cache key: %v", err)
 		http.Error(w, "Error reading cache", http.StatusInternalServerError)
-		return
+		return 0
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
+	storage := cache.ActiveStorage()
+	file, meta, err := storage.Get(key)
 	if err != nil {
-		log.Errorf("Error stating cache file: %v", err)
+		log.Errorf("Error reading cache: %v", err)
 		http.Error(w, "Error reading cache", http.StatusInternalServerError)
-		return
+		return 0
 	}
+	defer file.Close()
 
 	// Update access time
-	os.Chtimes(cachePath, time.Now(), info.ModTime())
+	storage.Touch(key, time.Now())
+
+	// Re-emit the upstream ETag/Content-Type so apt's conditional GETs keep
+	// working on a cache hit, same as they would against the real mirror.
+	// ServeContent itself handles If-None-Match/If-Modified-Since against
+	// whatever ETag/modtime we give it.
+	if meta.ETag != "" {
+		w.Header().Set("ETag", meta.ETag)
+	}
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
 
 	// Serve file
-	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
-	
-	stats.AddBytesServed(info.Size())
+	http.ServeContent(w, r, path.Base(cachePath), meta.ModTime, file)
+
+	stats.AddBytesServed(meta.Size)
+	metrics.AddBytesServed(distro, "hit", meta.Size)
+	metrics.ObserveResponseSize(distro, "hit", meta.Size)
+	return meta.Size
 }
 
 func (h *Handler) handleUnknown(w http.ResponseWriter, r *http.Request, path string) {
 	cfg := config.Get()
-	
+	start := time.Now()
+
 	if !cfg.PassthroughMode {
 		http.Error(w, "Unknown distro and passthrough disabled", http.StatusNotFound)
 		return
 	}
 
 	// Direct proxy
-	log := logger.Get()
-	
+	log := logger.FromContext(r.Context())
+
 	targetURL := r.URL.String()
 	if !strings.HasPrefix(targetURL, "http") {
 		http.Error(w, "Invalid proxy request", http.StatusBadRequest)
@@ -203,6 +407,19 @@ func (h *Handler) handleUnknown(w http.ResponseWriter, r *http.Request, path str
 	log.Infof("Direct proxying: %s", targetURL)
 	stats.AddLog(fmt.Sprintf("PROXY: %s", targetURL), "INFO")
 
+	if refused, forcedStatus := chaos.BeforeFetch(targetURL); refused {
+		log.Warnf("Chaos: connection refused to %s", targetURL)
+		http.Error(w, "connection refused (chaos injected)", http.StatusBadGateway)
+		metrics.RecordRequest("passthrough", "passthrough", http.StatusBadGateway)
+		logAccess(log, r, http.StatusBadGateway, 0, targetURL, time.Since(start))
+		return
+	} else if forcedStatus != 0 {
+		http.Error(w, fmt.Sprintf("HTTP %d (chaos injected)", forcedStatus), forcedStatus)
+		metrics.RecordRequest("passthrough", "passthrough", forcedStatus)
+		logAccess(log, r, forcedStatus, 0, targetURL, time.Since(start))
+		return
+	}
+
 	client := &http.Client{
 		Timeout: 60 * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -229,6 +446,7 @@ func (h *Handler) handleUnknown(w http.ResponseWriter, r *http.Request, path str
 	if err != nil {
 		log.Errorf("Proxy error: %v", err)
 		http.Error(w, err.Error(), http.StatusBadGateway)
+		metrics.RecordRequest("passthrough", "passthrough", http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
@@ -244,13 +462,17 @@ func (h *Handler) handleUnknown(w http.ResponseWriter, r *http.Request, path str
 	}
 
 	w.WriteHeader(resp.StatusCode)
+	metrics.RecordRequest("passthrough", "passthrough", resp.StatusCode)
 	written, _ := io.Copy(w, resp.Body)
 	stats.AddBytesServed(written)
+	metrics.AddBytesServed("passthrough", "passthrough", written)
+	metrics.ObserveResponseSize("passthrough", "passthrough", written)
+	logAccess(log, r, resp.StatusCode, written, "", time.Since(start))
 }
 
 func (h *Handler) handleConnect(w http.ResponseWriter, r *http.Request) {
-	log := logger.Get()
-	
+	log := logger.FromContext(r.Context())
+
 	target := r.Host
 	if target == "" {
 		target = r.URL.Host
@@ -4,18 +4,25 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"apt-cache-proxy/internal/auth"
 	"apt-cache-proxy/internal/cache"
+	"apt-cache-proxy/internal/cluster"
 	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/debidx"
 	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/metrics"
 	"apt-cache-proxy/internal/mirrors"
+	"apt-cache-proxy/internal/ociproxy"
 	"apt-cache-proxy/internal/proxy"
 	"apt-cache-proxy/internal/stats"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
@@ -28,6 +35,7 @@ var adminHTML string
 // New creates a new HTTP server with all routes
 func New(proxyHandler *proxy.Handler) *http.Server {
 	r := mux.NewRouter()
+	r.Use(requestIDMiddleware)
 
 	// Public routes (no auth)
 	r.HandleFunc("/health", healthHandler).Methods("GET")
@@ -36,10 +44,13 @@ func New(proxyHandler *proxy.Handler) *http.Server {
 	r.HandleFunc("/acng-report.html", dashboardHandler).Methods("GET")
 	r.HandleFunc("/", dashboardHandler).Methods("GET")
 	r.HandleFunc("/admin", adminHandler).Methods("GET")
-	
+	r.HandleFunc("/api/auth/login", loginHandler).Methods("POST")
+	r.HandleFunc("/api/auth/refresh", refreshHandler).Methods("POST")
+
 	// Admin API routes (authenticated)
 	api := r.PathPrefix("/api").Subrouter()
 	api.Use(authMiddleware)
+	api.Use(leaderRedirectMiddleware)
 	
 	api.HandleFunc("/admin/config", getConfigHandler).Methods("GET")
 	api.HandleFunc("/admin/config", updateConfigHandler).Methods("PUT")
@@ -47,17 +58,55 @@ func New(proxyHandler *proxy.Handler) *http.Server {
 	api.HandleFunc("/admin/mirrors", addMirrorHandler).Methods("POST")
 	api.HandleFunc("/admin/mirrors/{name}", updateMirrorHandler).Methods("PUT")
 	api.HandleFunc("/admin/mirrors/{name}", deleteMirrorHandler).Methods("DELETE")
+	api.HandleFunc("/admin/mirrors/{name}/probe", probeMirrorHandler).Methods("POST")
+	r.HandleFunc("/api/mirrors/health", mirrorHealthHandler).Methods("GET")
 	api.HandleFunc("/admin/cache", deleteCacheFileHandler).Methods("DELETE")
 	api.HandleFunc("/admin/blacklist", getBlacklistHandler).Methods("GET")
 	api.HandleFunc("/admin/blacklist", addBlacklistHandler).Methods("POST")
 	api.HandleFunc("/admin/blacklist", removeBlacklistHandler).Methods("DELETE")
 	api.HandleFunc("/admin/cleanup", cleanupHandler).Methods("POST")
+	api.HandleFunc("/admin/prefetch", prefetchHandler).Methods("POST")
 	api.HandleFunc("/reload", reloadHandler).Methods("POST")
+	api.HandleFunc("/admin/users", listUsersHandler).Methods("GET")
+	api.HandleFunc("/admin/users", createUserHandler).Methods("POST")
+	api.HandleFunc("/admin/users/{username}", deleteUserHandler).Methods("DELETE")
+	api.HandleFunc("/admin/chaos", getChaosHandler).Methods("GET")
+	api.HandleFunc("/admin/chaos", updateChaosHandler).Methods("PUT")
+	api.HandleFunc("/admin/access-counters", getAccessCountersHandler).Methods("GET")
+	api.HandleFunc("/admin/access-counters", resetAccessCountersHandler).Methods("DELETE")
+	api.HandleFunc("/admin/prefetch-queue", getPrefetchQueueHandler).Methods("GET")
+	api.HandleFunc("/admin/prefetch-queue/pause", pausePrefetchQueueHandler).Methods("POST")
+
+	// Cluster status/management endpoints
+	api.HandleFunc("/admin/cluster/peers", clusterPeersHandler).Methods("GET")
+	api.HandleFunc("/admin/cluster/leader", clusterLeaderHandler).Methods("GET")
+	api.HandleFunc("/admin/cluster/join", clusterJoinHandler).Methods("POST")
+	api.HandleFunc("/admin/cluster/leave", clusterLeaveHandler).Methods("POST")
+
+	// OCI/Docker registry pull-through (must come before the catch-all)
+	oci := ociproxy.NewHandler()
+	r.HandleFunc("/v2/", oci.HandleBase).Methods("GET")
+	r.HandleFunc("/v2/{registry}/{name:.+}/manifests/{reference}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		oci.HandleManifests(w, req, vars["registry"], vars["name"], vars["reference"])
+	}).Methods("GET")
+	r.HandleFunc("/v2/{registry}/{name:.+}/blobs/{digest}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		oci.HandleBlobs(w, req, vars["registry"], vars["name"], vars["digest"])
+	}).Methods("GET")
+
+	cfg := config.Get()
+	if cfg.MetricsEnabled {
+		if cfg.MetricsBind != "" {
+			go serveMetrics(cfg.MetricsBind)
+		} else {
+			r.Handle("/metrics", metrics.Handler()).Methods("GET")
+		}
+	}
 
 	// Catch-all proxy handler (must be last)
 	r.PathPrefix("/").HandlerFunc(proxyHandler.HandleAll)
 
-	cfg := config.Get()
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 
 	return &http.Server{
@@ -66,36 +115,353 @@ func New(proxyHandler *proxy.Handler) *http.Server {
 	}
 }
 
+// serveMetrics runs /metrics on its own listener, for operators who don't
+// want the Prometheus endpoint exposed alongside the public proxy port.
+func serveMetrics(bind string) {
+	log := logger.Get()
+	log.Infof("Serving Prometheus metrics on %s/metrics", bind)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if err := http.ListenAndServe(bind, mux); err != nil {
+		log.Errorf("Metrics listener stopped: %v", err)
+	}
+}
+
+// requestIDMiddleware assigns every incoming request a correlation ID, so
+// its whole lifecycle - across proxy.Handler's cache hit/miss and upstream
+// fetch log lines - can be grepped out as one group (see logger.FromContext).
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := uuid.NewString()
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logger.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// authMiddleware validates a JWT session issued by /api/auth/login and
+// enforces per-role access to the wrapped routes. cfg.AdminToken is kept as
+// a deprecated fallback for one release so existing single-token
+// deployments keep working until they provision users.
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg := config.Get()
-		token := cfg.AdminToken
-		
-		if token == "" {
-			next.ServeHTTP(w, r)
-			return
-		}
 
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+
+		if tokenStr != "" && cfg.JWTSecret != "" {
+			claims, err := auth.ParseToken(cfg.JWTSecret, tokenStr)
+			if err == nil && claims.Type == auth.AccessToken {
+				if !auth.Allowed(claims.Role, r.Method, r.URL.Path) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+				return
+			}
+		}
+
+		if cfg.AdminToken != "" {
+			logger.Get().Warn("Authenticated via legacy admin_token; this fallback is deprecated, migrate to /api/auth/login")
+			if tokenStr == cfg.AdminToken {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if cfg.AdminToken == "" && authHeader == "" {
+			// No users provisioned yet and no legacy token configured: behave
+			// like the original single-node default of no auth required.
+			if empty, err := auth.ListUsers(); err == nil && len(empty) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
 		}
 
-		receivedToken := authHeader
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			receivedToken = strings.TrimPrefix(authHeader, "Bearer ")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, err := auth.Authenticate(data.Username, data.Password)
+	if err != nil {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	cfg := config.Get()
+	accessToken, err := auth.IssueToken(cfg.JWTSecret, data.Username, role, auth.AccessToken, auth.AccessTokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := auth.IssueToken(cfg.JWTSecret, data.Username, role, auth.RefreshToken, auth.RefreshTokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"role":          string(role),
+	})
+}
+
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.Get()
+	claims, err := auth.ParseToken(cfg.JWTSecret, data.RefreshToken)
+	if err != nil || claims.Type != auth.RefreshToken {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := auth.IssueToken(cfg.JWTSecret, claims.Username, claims.Role, auth.AccessToken, auth.AccessTokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": accessToken})
+}
+
+func listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := auth.ListUsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Username string    `json:"username"`
+		Password string    `json:"password"`
+		Role     auth.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if data.Username == "" || data.Password == "" {
+		http.Error(w, "Missing username or password", http.StatusBadRequest)
+		return
+	}
+	if data.Role == "" {
+		data.Role = auth.RoleViewer
+	}
+
+	if err := auth.CreateUser(data.Username, data.Password, data.Role); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := auth.DeleteUser(vars["username"]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// getChaosHandler reports the current fault-injection state (see
+// internal/chaos) so integration tests can confirm a scenario is armed
+// before exercising it.
+func getChaosHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Get()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":               cfg.ChaosEnabled,
+		"mirror_faults":         cfg.ChaosMirrorFaults,
+		"client_bandwidth_kbps": cfg.ChaosClientBandwidthKbps,
+	})
+}
+
+// updateChaosHandler toggles fault injection and the client-side bandwidth
+// throttle at runtime. Per-mirror fault profiles are edited via
+// config.json and /api/reload, same as other structured config.
+func updateChaosHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Enabled             *bool `json:"enabled"`
+		ClientBandwidthKbps *int  `json:"client_bandwidth_kbps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if data.Enabled != nil {
+		config.Set("chaos_enabled", *data.Enabled)
+	}
+	if data.ClientBandwidthKbps != nil {
+		config.Set("chaos_client_bandwidth_kbps", *data.ClientBandwidthKbps)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// getAccessCountersHandler lists every tracked access_counters row, so an
+// operator can see which URLs are close to the cfg.CacheAfter admission
+// threshold (see cache.RecordAccess).
+func getAccessCountersHandler(w http.ResponseWriter, r *http.Request) {
+	counters, err := cache.ListAccessCounters()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(counters)
+}
+
+// resetAccessCountersHandler clears the counter for a single distro/pkg_path
+// pair, or every counter if neither query parameter is given - e.g. to force
+// a URL back below cfg.CacheAfter after raising the threshold.
+func resetAccessCountersHandler(w http.ResponseWriter, r *http.Request) {
+	distro := r.URL.Query().Get("distro")
+	pkgPath := r.URL.Query().Get("pkg_path")
+
+	var err error
+	if distro == "" && pkgPath == "" {
+		err = cache.ResetAllAccessCounters()
+	} else if distro == "" || pkgPath == "" {
+		http.Error(w, "distro and pkg_path must both be given, or both omitted", http.StatusBadRequest)
+		return
+	} else {
+		err = cache.ResetAccessCounter(distro, pkgPath)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// getPrefetchQueueHandler lists recent prefetch_queue entries (queued,
+// fetched, or failed) so an operator can see what the cachePrefetcher worker
+// (see internal/worker) has picked up off the back of debidx.IngestAndDiff.
+func getPrefetchQueueHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 200
+	entries, err := cache.ListPrefetchQueue(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// pausePrefetchQueueHandler toggles whether RunPrefetchBatch actually fetches
+// anything, without disturbing the queue itself - e.g. to quiet background
+// prefetching during a maintenance window without losing track of what's
+// pending.
+func pausePrefetchQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Paused bool `json:"paused"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := config.Set("prefetch_paused", data.Paused); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// leaderRedirectMiddleware forwards mutating admin requests to the Raft
+// leader when this node is a follower. Reads are served locally since the
+// cached state (mirrorsCache, blacklistPatterns, cfg) is kept up to date via
+// FSM.Apply on every node.
+func leaderRedirectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cluster.Enabled() || cluster.IsLeader() || r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		if receivedToken != token {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		leader := cluster.LeaderHTTPAddr()
+		if leader == "" {
+			http.Error(w, "No cluster leader available", http.StatusServiceUnavailable)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		w.Header().Set("X-Forwarded-Leader", leader)
+		http.Redirect(w, r, fmt.Sprintf("http://%s%s", leader, r.URL.RequestURI()), http.StatusTemporaryRedirect)
 	})
 }
 
+func clusterPeersHandler(w http.ResponseWriter, r *http.Request) {
+	peers, err := cluster.Peers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(peers)
+}
+
+func clusterLeaderHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{"leader": cluster.LeaderAddr()})
+}
+
+func clusterJoinHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		ID       string `json:"id"`
+		Addr     string `json:"addr"`
+		HTTPAddr string `json:"http_addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := cluster.Join(data.ID, data.Addr, data.HTTPAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "joined"})
+}
+
+func clusterLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := cluster.Leave(data.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "left"})
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
 	json.NewEncoder(w).Encode(map[string]string{
@@ -165,6 +531,7 @@ func addMirrorHandler(w http.ResponseWriter, r *http.Request) {
 		Name   string   `json:"name"`
 		URLs   []string `json:"urls"`
 		Status string   `json:"status"`
+		Type   string   `json:"type"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -181,7 +548,7 @@ func addMirrorHandler(w http.ResponseWriter, r *http.Request) {
 		data.Status = "approved"
 	}
 
-	if err := mirrors.Save(data.Name, data.URLs, data.Status); err != nil {
+	if err := mirrors.SaveTyped(data.Name, data.URLs, data.Status, data.Type); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -211,6 +578,23 @@ func updateMirrorHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+func probeMirrorHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	cfg := config.Get()
+	if err := mirrors.ProbeMirror(name, cfg.MirrorProbePath, cfg.MirrorFailureThreshold); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "probed"})
+}
+
+func mirrorHealthHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(mirrors.GetHealth())
+}
+
 func deleteMirrorHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
@@ -294,12 +678,26 @@ func reloadHandler(w http.ResponseWriter, r *http.Request) {
 	mirrors.LoadFromDB()
 	cache.LoadBlacklistFromDB()
 
+	cfg := config.Get()
+	logger.Configure(logger.Settings{
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAgeDays: cfg.LogMaxAgeDays,
+		Level:      cfg.LogLevel,
+	})
+
 	log := logger.Get()
 	log.Info("Configuration reloaded")
 
 	json.NewEncoder(w).Encode(map[string]string{"status": "reloaded"})
 }
 
+// cacheSearchHandler searches the debidx package index by real package name
+// (Package/Version from the distro's Packages files), falling back to a
+// filename substring walk of storage for anything debidx hasn't indexed yet
+// (e.g. non-APT files pulled via passthrough mode).
 func cacheSearchHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
@@ -307,19 +705,41 @@ func cacheSearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+
+	pkgs, err := debidx.Search(query, 100)
+	if err == nil && len(pkgs) > 0 {
+		results := make([]map[string]interface{}, 0, len(pkgs))
+		for _, pkg := range pkgs {
+			results = append(results, map[string]interface{}{
+				"name":         pkg.Name,
+				"version":      pkg.Version,
+				"architecture": pkg.Architecture,
+				"filename":     pkg.Filename,
+				"sha256":       pkg.SHA256,
+				"size":         pkg.Size,
+			})
+		}
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	json.NewEncoder(w).Encode(filenameSearch(query))
+}
+
+// filenameSearch is the original substring-over-filenames search, kept as a
+// fallback for files debidx has not indexed.
+func filenameSearch(query string) []map[string]interface{} {
 	cfg := config.Get()
 	var results []map[string]interface{}
 
-	// Simple file search implementation
-	// Walk through storage directory and find matching files
-	err := filepath.Walk(cfg.StoragePathResolved, func(path string, info os.FileInfo, err error) error {
+	filepath.Walk(cfg.StoragePathResolved, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
 		}
 
 		filename := filepath.Base(path)
 		if strings.Contains(strings.ToLower(filename), strings.ToLower(query)) {
-			// Extract distro from path
 			relPath, _ := filepath.Rel(cfg.StoragePathResolved, path)
 			distro := strings.Split(relPath, string(filepath.Separator))[0]
 
@@ -333,19 +753,84 @@ func cacheSearchHandler(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 
-		// Limit results to 100
 		if len(results) >= 100 {
 			return filepath.SkipDir
 		}
-
 		return nil
 	})
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	return results
+}
+
+// prefetchHandler resolves the given package names against the debidx index
+// and warms the cache in the background using the existing mirror pool.
+func prefetchHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Distro   string   `json:"distro"`
+		Suite    string   `json:"suite"`
+		Packages []string `json:"packages"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	if data.Distro == "" || data.Suite == "" || len(data.Packages) == 0 {
+		http.Error(w, "Missing distro, suite or packages", http.StatusBadRequest)
+		return
+	}
+
+	allMirrors := mirrors.GetAll()
+	mirrorURLs, ok := allMirrors[mirrors.GetUpstreamKey(data.Distro, "")]
+	if !ok {
+		mirrorURLs, ok = allMirrors[data.Distro]
+	}
+	if !ok {
+		http.Error(w, "Unknown distro", http.StatusNotFound)
+		return
+	}
+
+	log := logger.Get()
+	queued := 0
+
+	for _, name := range data.Packages {
+		pkg, found := debidx.Resolve(data.Distro, data.Suite, name)
+		if !found || cache.IsBlacklisted(pkg.Filename) {
+			continue
+		}
+
+		queued++
+		go func(pkg debidx.Package) {
+			cachePath := cache.GetCachePath(data.Distro, pkg.Filename)
+			if !cache.IsCacheValid(cachePath) {
+				cache.TryDedupeFromPool(data.Distro, pkg.Filename, cachePath)
+			}
+			if cache.IsCacheValid(cachePath) {
+				return
+			}
+
+			targets := make([]mirrors.FetchTarget, len(mirrorURLs))
+			for i, m := range mirrorURLs {
+				targets[i] = mirrors.FetchTarget{
+					URL:    fmt.Sprintf("%s/%s", strings.TrimSuffix(m, "/"), pkg.Filename),
+					Mirror: m,
+				}
+			}
+
+			resp, _, err := cache.StreamAndCache(targets, cachePath, nil, data.Distro, pkg.Filename)
+			if err != nil {
+				log.Warnf("Prefetch failed for %s: %v", pkg.Filename, err)
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			log.Infof("Prefetched: %s", pkg.Filename)
+		}(pkg)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "prefetch started",
+		"queued": queued,
+	})
 }
@@ -1,15 +1,16 @@
 package stats
 
 import (
-	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"apt-cache-proxy/internal/config"
+	"apt-cache-proxy/internal/cache"
 	"apt-cache-proxy/internal/database"
 	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/metrics"
 )
 
 // Stats holds runtime statistics with atomic counters for thread-safety
@@ -100,7 +101,6 @@ func GetFileStats() map[string]interface{} {
 
 // UpdateFileStats recalculates file statistics (expensive operation)
 func UpdateFileStats() error {
-	cfg := config.Get()
 	log := logger.Get()
 	
 	log.Debug("Starting file stats update...")
@@ -108,61 +108,29 @@ func UpdateFileStats() error {
 	totalFiles := int64(0)
 	totalSize := int64(0)
 	distroStats := make(map[string]DistroStat)
-	
-	// Walk the storage directory
-	entries, err := os.ReadDir(cfg.StoragePathResolved)
+
+	// Walk the active cache backend (local disk, see cache.ActiveStorage) and bucket by the
+	// first path segment of each key, which is always the distro name (see
+	// cache.GetCachePath).
+	err := cache.ActiveStorage().Walk("", func(key string, meta cache.Meta) error {
+		distro := strings.SplitN(filepath.ToSlash(key), "/", 2)[0]
+		totalFiles++
+		totalSize += meta.Size
+
+		d := distroStats[distro]
+		d.Files++
+		d.Size += meta.Size
+		distroStats[distro] = d
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	
-	// Process each distro directory concurrently
-	type result struct {
-		distro string
-		files  int64
-		size   int64
-	}
-	
-	results := make(chan result, len(entries))
-	var wg sync.WaitGroup
-	
-	for _, entry := range entries {
-		if !entry.IsDir() || entry.Name()[0] == '.' {
-			continue
-		}
-		
-		wg.Add(1)
-		go func(distroName string) {
-			defer wg.Done()
-			
-			distroPath := filepath.Join(cfg.StoragePathResolved, distroName)
-			var dFiles, dSize int64
-			
-			filepath.Walk(distroPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil || info.IsDir() {
-					return nil
-				}
-				dFiles++
-				dSize += info.Size()
-				return nil
-			})
-			
-			results <- result{distro: distroName, files: dFiles, size: dSize}
-		}(entry.Name())
-	}
-	
-	// Close results channel when all goroutines finish
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	
-	// Collect results
-	for r := range results {
-		totalFiles += r.files
-		totalSize += r.size
-		distroStats[r.distro] = DistroStat{Files: r.files, Size: r.size}
+
+	for distro, d := range distroStats {
+		metrics.SetCacheStats(distro, d.Files, d.Size)
 	}
-	
+
 	// Update global stats
 	fileStats.mu.Lock()
 	fileStats.TotalFiles = totalFiles
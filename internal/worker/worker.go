@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"apt-cache-proxy/internal/cache"
+	"apt-cache-proxy/internal/config"
 	"apt-cache-proxy/internal/logger"
+	"apt-cache-proxy/internal/mirrors"
 	"apt-cache-proxy/internal/stats"
 )
 
@@ -22,6 +24,15 @@ func Start(ctx context.Context) {
 
 	// Worker 3: Clean cache every hour
 	go cacheCleaner(ctx)
+
+	// Worker 4: Probe mirror health on a configurable interval
+	go mirrorProber(ctx)
+
+	// Worker 5: Scrub cached files for bitrot every 10 minutes
+	go cacheScrubber(ctx)
+
+	// Worker 6: Fetch newly-indexed packages ahead of client requests every 5 minutes
+	go cachePrefetcher(ctx)
 }
 
 func statsSaver(ctx context.Context) {
@@ -61,6 +72,27 @@ func fileStatsUpdater(ctx context.Context) {
 	}
 }
 
+func mirrorProber(ctx context.Context) {
+	log := logger.Get()
+	cfg := config.Get()
+	interval := time.Duration(cfg.MirrorProbeIntervalMinutes) * time.Minute
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Mirror prober worker stopped")
+			return
+		case <-ticker.C:
+			cfg := config.Get()
+			log.Debug("Probing mirror health...")
+			mirrors.ProbeAll(cfg.MirrorProbePath, cfg.MirrorFailureThreshold)
+		}
+	}
+}
+
 func cacheCleaner(ctx context.Context) {
 	log := logger.Get()
 	ticker := time.NewTicker(1 * time.Hour)
@@ -79,3 +111,41 @@ func cacheCleaner(ctx context.Context) {
 		}
 	}
 }
+
+func cacheScrubber(ctx context.Context) {
+	log := logger.Get()
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Cache scrubber worker stopped")
+			return
+		case <-ticker.C:
+			log.Debug("Scrubbing cache for bitrot...")
+			if err := cache.ScrubOnce(); err != nil {
+				log.Errorf("Cache scrub failed: %v", err)
+			}
+		}
+	}
+}
+
+func cachePrefetcher(ctx context.Context) {
+	log := logger.Get()
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Cache prefetcher worker stopped")
+			return
+		case <-ticker.C:
+			log.Debug("Running prefetch batch...")
+			if err := cache.RunPrefetchBatch(); err != nil {
+				log.Errorf("Prefetch batch failed: %v", err)
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"apt-cache-proxy/internal/cache"
+	"apt-cache-proxy/internal/cluster"
 	"apt-cache-proxy/internal/config"
 	"apt-cache-proxy/internal/database"
 	"apt-cache-proxy/internal/logger"
@@ -30,12 +31,37 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Apply config-driven logging settings (format, rotation) now that
+	// config is available
+	cfg := config.Get()
+	logger.Configure(logger.Settings{
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAgeDays: cfg.LogMaxAgeDays,
+		Level:      cfg.LogLevel,
+	})
+
 	// Initialize database
 	if err := database.Init(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
+	// Start cluster node (no-op when cluster.enabled is false)
+	clusterCfg := cluster.Config{
+		Enabled:   cfg.ClusterEnabled,
+		Bind:      cfg.ClusterBind,
+		Bootstrap: cfg.ClusterBootstrap,
+		Peers:     cfg.ClusterPeers,
+		BaseDir:   cfg.BaseDir,
+		HTTPAddr:  fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+	}
+	if err := cluster.Start(clusterCfg); err != nil {
+		log.Fatalf("Failed to start cluster node: %v", err)
+	}
+
 	// Load data from database
 	if err := stats.LoadFromDB(); err != nil {
 		log.Warnf("Failed to load stats from DB: %v", err)
@@ -65,8 +91,7 @@ func main() {
 
 	// Create HTTP server
 	srv := server.New(proxyHandler)
-	
-	cfg := config.Get()
+
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 	log.Infof("Starting APT Cache Proxy on %s", addr)
 